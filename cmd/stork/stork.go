@@ -26,6 +26,7 @@ import (
 	"github.com/libopenstorage/stork/pkg/dbg"
 	"github.com/libopenstorage/stork/pkg/extender"
 	"github.com/libopenstorage/stork/pkg/groupsnapshot"
+	"github.com/libopenstorage/stork/pkg/k8sutils"
 	"github.com/libopenstorage/stork/pkg/metrics"
 	"github.com/libopenstorage/stork/pkg/migration"
 	"github.com/libopenstorage/stork/pkg/monitor"
@@ -205,10 +206,11 @@ func run(c *cli.Context) {
 	dbg.Init(c.App.Name, debugFilePath)
 
 	log.Infof("Starting stork version %v", version.Version)
+	k8sutils.SetStorkVersion(version.Version)
 	// create configmap with stork version details
 	cm := &api_v1.ConfigMap{}
 	cm.Name = cmName
-	cm.Namespace = defaultAdminNamespace
+	cm.Namespace = k8sutils.GetAdminNamespace()
 	cm.Data = make(map[string]string)
 	cm.Data[storkVersion] = version.Version
 	// ConfigMap create/update op should not be blocking operation
@@ -263,6 +265,17 @@ func run(c *cli.Context) {
 		log.Fatalf("Error getting client, %v", err)
 	}
 
+	// The k8sutils clients are used by the controllers and drivers, so they
+	// are limited by the same QPS and burst as the resource collector
+	k8sutilsConfig := rest.CopyConfig(config)
+	if qps := c.Int("k8s-api-qps"); qps > 0 {
+		k8sutilsConfig.QPS = float32(qps)
+	}
+	if burst := c.Int("k8s-api-burst"); burst > 0 {
+		k8sutilsConfig.Burst = burst
+	}
+	k8sutils.SetConfig(k8sutilsConfig)
+
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartRecordingToSink(&core_v1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, api_v1.EventSource{Component: eventComponentName})
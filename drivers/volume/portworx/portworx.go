@@ -454,6 +454,55 @@ func (p *portworx) InspectVolume(volumeID string) (*storkvolume.Info, error) {
 	return p.inspectVolume(volDriver, volumeID)
 }
 
+// getVolumeState returns the state of the given volume, like VOLUME_STATE_ATTACHED
+func (p *portworx) getVolumeState(volumeID string) (string, error) {
+	info, err := p.InspectVolume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	vol, ok := info.VolumeSourceRef.(*api.Volume)
+	if !ok {
+		return "", fmt.Errorf("invalid volume source for volume %v", volumeID)
+	}
+	return vol.State.String(), nil
+}
+
+// getVolumeUsage returns the number of bytes used by the given volume
+func (p *portworx) getVolumeUsage(volumeID string) (uint64, error) {
+	info, err := p.InspectVolume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	vol, ok := info.VolumeSourceRef.(*api.Volume)
+	if !ok {
+		return 0, fmt.Errorf("invalid volume source for volume %v", volumeID)
+	}
+	return vol.Usage, nil
+}
+
+// getVolumeNodes returns the hostnames of the nodes that have a replica of the
+// given volume
+func (p *portworx) getVolumeNodes(volumeID string) ([]string, error) {
+	info, err := p.InspectVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := p.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	hostnames := make([]string, 0, len(info.DataNodes))
+	for _, dataNode := range info.DataNodes {
+		for _, node := range nodes {
+			if node.StorageID == dataNode {
+				hostnames = append(hostnames, node.Hostname)
+				break
+			}
+		}
+	}
+	return hostnames, nil
+}
+
 func (p *portworx) inspectVolume(volDriver volume.VolumeDriver, volumeID string) (*storkvolume.Info, error) {
 	vols, err := volDriver.Inspect([]string{volumeID})
 	if err != nil {
@@ -583,6 +632,23 @@ func (p *portworx) InspectNode(id string) (*storkvolume.NodeInfo, error) {
 	}, nil
 }
 
+// checkCredentials enumerates the cluster to check that stork can authenticate
+// with Portworx
+func (p *portworx) checkCredentials() error {
+	if !p.initDone {
+		if err := p.initPortworxClients(); err != nil {
+			return err
+		}
+	}
+
+	clusterManager, err := p.getClusterManagerClient()
+	if err != nil {
+		return fmt.Errorf("cannot get cluster manager, err: %s", err.Error())
+	}
+	_, err = clusterManager.Enumerate()
+	return err
+}
+
 func (p *portworx) GetNodes() ([]*storkvolume.NodeInfo, error) {
 	if !p.initDone {
 		if err := p.initPortworxClients(); err != nil {
@@ -3922,4 +3988,8 @@ func init() {
 	if err := storkvolume.Register(storkvolume.PortworxDriverName, p); err != nil {
 		logrus.Panicf("Error registering portworx volume driver: %v", err)
 	}
+	k8sutils.RegisterVolumeStateFunc(storkvolume.PortworxDriverName, p.getVolumeState)
+	k8sutils.RegisterVolumeUsageFunc(storkvolume.PortworxDriverName, p.getVolumeUsage)
+	k8sutils.RegisterDriverAuthCheckFunc(storkvolume.PortworxDriverName, p.checkCredentials)
+	k8sutils.RegisterVolumeNodesFunc(storkvolume.PortworxDriverName, p.getVolumeNodes)
 }
@@ -49,6 +49,8 @@ require (
 	github.com/portworx/sched-ops v1.20.4-rc1.0.20220310041017-b5bae9ba82a7
 	github.com/portworx/torpedo v0.20.4-rc1.0.20210325154352-eb81b0cdd145
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
 	github.com/rs/cors v1.6.1-0.20190116175910-76f58f330d76 // indirect
 	github.com/sirupsen/logrus v1.8.1
 	github.com/skyrings/skyring-common v0.0.0-20160929130248-d1c0bb1cbd5e
@@ -168,6 +168,11 @@ func (m *GroupSnapshotController) handle(ctx context.Context, groupSnapshot *sto
 		}
 	}
 
+	if groupSnapshot.Status.Stage != stork_api.GroupSnapshotStageFinal &&
+		k8sutils.IsGroupSnapshotCancelled(groupSnapshot) {
+		return m.handleCancel(ctx, groupSnapshot)
+	}
+
 	var updateCRDForThisEvent bool
 	switch groupSnapshot.Status.Stage {
 	case stork_api.GroupSnapshotStageInitial,
@@ -741,6 +746,75 @@ func (m *GroupSnapshotController) handleFinal(groupSnap *stork_api.GroupVolumeSn
 	return nil
 }
 
+// handleCancel aborts a group snapshot that has been cancelled with
+// k8sutils.CancelGroupSnapshot. Background commands of the pre-exec rule are
+// terminated and the member VolumeSnapshots that aren't ready are deleted. The
+// post-exec rule is run to un-quiesce the apps if the pre-exec rule has been
+// run. The group snapshot is then failed in its final stage, keeping only the
+// snapshots that are ready in its status.
+func (m *GroupSnapshotController) handleCancel(ctx context.Context, groupSnap *stork_api.GroupVolumeSnapshot) error {
+	log.GroupSnapshotLog(groupSnap).Infof("Cancelling group snapshot")
+	snapUID := string(groupSnap.ObjectMeta.UID)
+	if backgroundChannel, present := m.bgChannelsForRules[snapUID]; present {
+		backgroundChannel <- true
+		delete(m.bgChannelsForRules, snapUID)
+	}
+
+	readySnapshots := make([]*stork_api.VolumeSnapshotStatus, 0)
+	for _, snapshot := range groupSnap.Status.VolumeSnapshots {
+		// Snapshots without a VolumeSnapshot are still in progress in the driver
+		if len(snapshot.VolumeSnapshotName) == 0 {
+			continue
+		}
+		if areAllSnapshotsDone([]*stork_api.VolumeSnapshotStatus{snapshot}) {
+			_, err := k8sextops.Instance().GetSnapshot(snapshot.VolumeSnapshotName, groupSnap.Namespace)
+			if err == nil {
+				readySnapshots = append(readySnapshots, snapshot)
+			} else if !errors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		err := k8sextops.Instance().DeleteSnapshot(snapshot.VolumeSnapshotName, groupSnap.Namespace)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if groupSnap.Status.Stage != stork_api.GroupSnapshotStageInitial &&
+		groupSnap.Status.Stage != stork_api.GroupSnapshotStagePreChecks &&
+		len(groupSnap.Spec.PostExecRule) > 0 {
+		log.GroupSnapshotLog(groupSnap).Infof("Running post-snapshot rule: %s", groupSnap.Spec.PostExecRule)
+		r, err := storkops.Instance().GetRule(groupSnap.Spec.PostExecRule, groupSnap.Namespace)
+		if err != nil {
+			return err
+		}
+		if _, err := rule.ExecuteRule(r, rule.PostExecRule, groupSnap, groupSnap.Namespace); err != nil {
+			return err
+		}
+
+		// refresh the latest groupSnap as ExecuteRule might have updated it
+		groupSnap, err = storkops.Instance().GetGroupSnapshot(groupSnap.GetName(), groupSnap.GetNamespace())
+		if err != nil {
+			return err
+		}
+	}
+
+	groupSnap.Status.VolumeSnapshots = readySnapshots
+	groupSnap.Status.Status = stork_api.GroupSnapshotFailed
+	groupSnap.Status.Stage = stork_api.GroupSnapshotStageFinal
+	SetKind(groupSnap)
+	if err := m.client.Update(ctx, groupSnap); err != nil {
+		return err
+	}
+	m.minResourceVersions[string(groupSnap.UID)] = groupSnap.ResourceVersion
+	m.recorder.Event(groupSnap,
+		v1.EventTypeWarning,
+		string(stork_api.GroupSnapshotFailed),
+		"Group snapshot was cancelled")
+	return nil
+}
+
 func (m *GroupSnapshotController) handleDelete(groupSnap *stork_api.GroupVolumeSnapshot) error {
 	// no need to track minResourceVersion for this group snap any longer
 	delete(m.minResourceVersions, string(groupSnap.UID))
@@ -0,0 +1,104 @@
+package k8sutils
+
+import (
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// StorkAnnotationPrefix - prefix used for all annotations and labels honored by stork
+	StorkAnnotationPrefix = "stork.libopenstorage.org/"
+	// SkipResourceAnnotation - annotation to skip a resource during backup,
+	// migration and snapshot operations
+	SkipResourceAnnotation = StorkAnnotationPrefix + "skip-resource"
+	// SkipResourceAnnotationDeprecated - deprecated form of SkipResourceAnnotation
+	SkipResourceAnnotationDeprecated = StorkAnnotationPrefix + "skipresource"
+	// SkipModifyResourceAnnotation - annotation to prevent stork from modifying a
+	// resource when it is migrated or restored
+	SkipModifyResourceAnnotation = StorkAnnotationPrefix + "skip-modify-resource"
+	// SkipSchedulerScoringLabel - label or annotation to skip scoring a volume in the
+	// scheduler extender
+	SkipSchedulerScoringLabel = StorkAnnotationPrefix + "skipSchedulerScoring"
+	// DisableHyperconvergenceAnnotation - annotation on pods to disable
+	// hyperconvergence in the scheduler extender
+	DisableHyperconvergenceAnnotation = StorkAnnotationPrefix + "disableHyperconvergence"
+	// PreferLocalNodeOnlyAnnotation - annotation on pods to only schedule them on
+	// nodes that have a replica of their volumes
+	PreferLocalNodeOnlyAnnotation = StorkAnnotationPrefix + "preferLocalNodeOnly"
+	// DisableAdmissionControllerAnnotation - annotation on apps to stop the webhook
+	// from setting stork as their scheduler
+	DisableAdmissionControllerAnnotation = StorkAnnotationPrefix + "disable-admission-controller"
+	// StorkDisabledNamespaceLabel - label or annotation on namespaces that should
+	// be ignored by stork
+	StorkDisabledNamespaceLabel = StorkAnnotationPrefix + "disabled"
+	// StorkMigrationAnnotation - annotation set on resources created by a migration
+	StorkMigrationAnnotation = StorkAnnotationPrefix + "migrated"
+	// StorkMigrationSourceClusterAnnotation - annotation on migrated resources with
+	// the name of the cluster they were migrated from
+	StorkMigrationSourceClusterAnnotation = StorkAnnotationPrefix + "migrationSourceCluster"
+	// StorkMigrationSourceNamespaceAnnotation - annotation on migrated resources
+	// with the namespace they were migrated from
+	StorkMigrationSourceNamespaceAnnotation = StorkAnnotationPrefix + "migrationSourceNamespace"
+	// GroupSnapshotCancelAnnotation - annotation set on a group snapshot by
+	// CancelGroupSnapshot to have the group snapshot controller abort it
+	GroupSnapshotCancelAnnotation = StorkAnnotationPrefix + "cancel-group-snapshot"
+)
+
+// IsSnapshotSkipped returns true if the object has been annotated to be skipped
+// by stork
+func IsSnapshotSkipped(obj metav1.Object) bool {
+	annotations := obj.GetAnnotations()
+	if _, present := annotations[SkipResourceAnnotation]; present {
+		return isAnnotationTrue(annotations, SkipResourceAnnotation)
+	}
+	return isAnnotationTrue(annotations, SkipResourceAnnotationDeprecated)
+}
+
+// IsModifyResourceSkipped returns true if stork should not modify the object
+// when migrating or restoring it
+func IsModifyResourceSkipped(obj metav1.Object) bool {
+	_, present := obj.GetAnnotations()[SkipModifyResourceAnnotation]
+	return present
+}
+
+// IsSchedulerScoringSkipped returns true if the object has been labeled or
+// annotated to be skipped by the scheduler extender's scoring
+func IsSchedulerScoringSkipped(obj metav1.Object) bool {
+	return isAnnotationTrue(obj.GetLabels(), SkipSchedulerScoringLabel) ||
+		isAnnotationTrue(obj.GetAnnotations(), SkipSchedulerScoringLabel)
+}
+
+// IsHyperconvergenceDisabled returns true if hyperconvergence has been disabled
+// for the object
+func IsHyperconvergenceDisabled(obj metav1.Object) bool {
+	return isAnnotationTrue(obj.GetAnnotations(), DisableHyperconvergenceAnnotation)
+}
+
+// IsPreferLocalNodeOnly returns true if the object should only be placed on nodes
+// local to its volumes
+func IsPreferLocalNodeOnly(obj metav1.Object) bool {
+	return isAnnotationTrue(obj.GetAnnotations(), PreferLocalNodeOnlyAnnotation)
+}
+
+// IsAdmissionControllerDisabled returns true if the stork webhook should not
+// modify the object
+func IsAdmissionControllerDisabled(obj metav1.Object) bool {
+	return isAnnotationTrue(obj.GetAnnotations(), DisableAdmissionControllerAnnotation)
+}
+
+// IsGroupSnapshotCancelled returns true if the group snapshot has been cancelled
+// with CancelGroupSnapshot
+func IsGroupSnapshotCancelled(obj metav1.Object) bool {
+	return isAnnotationTrue(obj.GetAnnotations(), GroupSnapshotCancelAnnotation)
+}
+
+// isAnnotationTrue returns true if the given key is present and parses as true
+func isAnnotationTrue(annotations map[string]string, key string) bool {
+	value, present := annotations[key]
+	if !present {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && enabled
+}
@@ -0,0 +1,74 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	backupLocationGVR = schema.GroupVersionResource{
+		Group:    "stork.libopenstorage.org",
+		Version:  "v1alpha1",
+		Resource: "backuplocations",
+	}
+
+	// backupLocationConfigs are the inline config field and the credential keys
+	// that can be set for each type of BackupLocation. Values in the secret
+	// referenced by secretConfig override the inline ones.
+	backupLocationConfigs = map[string]struct {
+		field string
+		keys  []string
+	}{
+		"s3":     {"s3Config", []string{"endpoint", "accessKeyID", "secretAccessKey", "region", "disableSSL", "storageClass"}},
+		"azure":  {"azureConfig", []string{"storageAccountName", "storageAccountKey"}},
+		"google": {"googleConfig", []string{"projectID", "accountKey"}},
+	}
+)
+
+// GetBackupLocationCredentials returns the credentials used to access the object
+// store of the given BackupLocation, keyed by field name. Credentials set inline
+// in the BackupLocation are merged with the ones from its secretConfig, with the
+// secret taking precedence like it does for the backup controller.
+func GetBackupLocationCredentials(backupLocationName, namespace string) (map[string]string, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	backupLocation, err := client.Resource(backupLocationGVR).Namespace(namespace).Get(context.TODO(), backupLocationName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting backuplocation [%s] %s: %v", namespace, backupLocationName, err)
+	}
+
+	locationType, _, _ := unstructured.NestedString(backupLocation.Object, "location", "type")
+	config, ok := backupLocationConfigs[locationType]
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q for backuplocation [%s] %s", locationType, namespace, backupLocationName)
+	}
+
+	credentials := make(map[string]string)
+	inline, _, _ := unstructured.NestedMap(backupLocation.Object, "location", config.field)
+	for _, key := range config.keys {
+		if value, ok := inline[key]; ok && value != nil {
+			credentials[key] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	secretName, _, _ := unstructured.NestedString(backupLocation.Object, "location", "secretConfig")
+	if secretName != "" {
+		secret, err := Instance().Core().GetSecret(secretName, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("error getting secretConfig [%s] %s for backuplocation %s: %v", namespace, secretName, backupLocationName, err)
+		}
+		for _, key := range config.keys {
+			if value, ok := secret.Data[key]; ok && value != nil {
+				credentials[key] = strings.TrimSuffix(string(value), "\n")
+			}
+		}
+	}
+	return credentials, nil
+}
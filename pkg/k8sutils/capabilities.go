@@ -0,0 +1,59 @@
+package k8sutils
+
+import (
+	"fmt"
+
+	version "github.com/hashicorp/go-version"
+)
+
+const (
+	// StorkCapabilityGroupSnapshot - snapshots of a group of PVCs using GroupVolumeSnapshots
+	StorkCapabilityGroupSnapshot = "groupSnapshot"
+	// StorkCapabilityApplicationBackup - backup and restore of applications to an object store
+	StorkCapabilityApplicationBackup = "applicationBackup"
+	// StorkCapabilityCSISnapshot - snapshots of CSI volumes using VolumeSnapshots
+	StorkCapabilityCSISnapshot = "csiSnapshot"
+	// StorkCapabilityGenericCSIMigration - migration of CSI volumes from any driver
+	// using the data mover
+	StorkCapabilityGenericCSIMigration = "genericCSIMigration"
+	// StorkCapabilityRWXGroupSnapshot - group snapshots of ReadWriteMany volumes
+	StorkCapabilityRWXGroupSnapshot = "rwxGroupSnapshot"
+)
+
+var (
+	// storkCapabilityVersions are the minimum stork versions that support each capability
+	storkCapabilityVersions = map[string]string{
+		StorkCapabilityGroupSnapshot:       "2.0.0",
+		StorkCapabilityApplicationBackup:   "2.2.0",
+		StorkCapabilityCSISnapshot:         "2.5.0",
+		StorkCapabilityGenericCSIMigration: "2.7.0",
+		StorkCapabilityRWXGroupSnapshot:    "2.8.0",
+	}
+)
+
+// GetStorkCapabilities returns whether each of the StorkCapability* features is
+// supported by the version of stork deployed in the given namespace
+func GetStorkCapabilities(namespace string) (map[string]bool, error) {
+	tag, err := GetStorkVersionFromDeployment(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return getCapabilitiesForVersion(tag)
+}
+
+// getCapabilitiesForVersion maps the stork version to the capabilities it supports
+func getCapabilitiesForVersion(storkVersion string) (map[string]bool, error) {
+	current, err := version.NewVersion(normalizeStorkVersion(storkVersion))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse stork version %s: %v", storkVersion, err)
+	}
+	capabilities := make(map[string]bool)
+	for capability, minVersion := range storkCapabilityVersions {
+		required, err := version.NewVersion(minVersion)
+		if err != nil {
+			return nil, err
+		}
+		capabilities[capability] = current.GreaterThanOrEqual(required)
+	}
+	return capabilities, nil
+}
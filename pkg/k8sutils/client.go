@@ -0,0 +1,233 @@
+package k8sutils
+
+import (
+	"os"
+	"sync"
+
+	"github.com/portworx/sched-ops/k8s/admissionregistration"
+	"github.com/portworx/sched-ops/k8s/apiextensions"
+	"github.com/portworx/sched-ops/k8s/apps"
+	"github.com/portworx/sched-ops/k8s/batch"
+	"github.com/portworx/sched-ops/k8s/core"
+	"github.com/portworx/sched-ops/k8s/storage"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// K8sUtils provides the clients used by the functions in this package
+type K8sUtils interface {
+	// Core returns the ops for the core kubernetes resources
+	Core() core.Ops
+	// Apps returns the ops for deployments, statefulsets and other apps resources
+	Apps() apps.Ops
+	// Storage returns the ops for storage classes
+	Storage() storage.Ops
+	// Batch returns the ops for jobs
+	Batch() batch.Ops
+	// Extensions returns the ops for CRDs
+	Extensions() apiextensions.Ops
+	// AdmissionRegistration returns the ops for webhook configurations
+	AdmissionRegistration() admissionregistration.Ops
+	// KubernetesClient returns a kubernetes clientset for operations that aren't
+	// exposed through Core() and Apps()
+	KubernetesClient() (kubernetes.Interface, error)
+	// ExtensionsClient returns an apiextensions clientset for operations that
+	// aren't exposed through Extensions()
+	ExtensionsClient() (clientset.Interface, error)
+	// DynamicClient returns a dynamic client for resources that don't have typed
+	// clients, like CSI snapshots and stork CRs
+	DynamicClient() (dynamic.Interface, error)
+}
+
+var (
+	instance     K8sUtils = &defaultK8sUtils{}
+	instanceLock sync.RWMutex
+)
+
+// Instance returns the K8sUtils used by the functions in this package
+func Instance() K8sUtils {
+	instanceLock.RLock()
+	defer instanceLock.RUnlock()
+	return instance
+}
+
+// SetInstance replaces the K8sUtils used by the functions in this package, for
+// example with one created by NewForClients from fake clientsets in tests.
+// Passing nil restores the default, which uses the sched-ops singletons.
+func SetInstance(i K8sUtils) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	if i == nil {
+		i = &defaultK8sUtils{}
+	}
+	instance = i
+}
+
+// defaultK8sUtils uses the sched-ops singletons and creates the other clients
+// once from the config given to SetConfig, or from the one returned by
+// getRestConfig if no config was set
+type defaultK8sUtils struct {
+	config *rest.Config
+
+	once          sync.Once
+	kubeClient    kubernetes.Interface
+	extClient     *clientset.Clientset
+	dynamicClient dynamic.Interface
+	err           error
+}
+
+// SetConfig sets the config used to create the clients returned by Instance(),
+// which should be the same config that is given to the sched-ops singletons.
+// The clients are created again for the new config on their next use.
+func SetConfig(config *rest.Config) {
+	SetInstance(&defaultK8sUtils{config: config})
+}
+
+func (d *defaultK8sUtils) initClients() error {
+	d.once.Do(func() {
+		config := d.config
+		if config == nil {
+			if config, d.err = getRestConfig(); d.err != nil {
+				return
+			}
+		}
+		if d.kubeClient, d.err = kubernetes.NewForConfig(config); d.err != nil {
+			return
+		}
+		if d.extClient, d.err = clientset.NewForConfig(config); d.err != nil {
+			return
+		}
+		d.dynamicClient, d.err = dynamic.NewForConfig(config)
+	})
+	return d.err
+}
+
+func (d *defaultK8sUtils) Core() core.Ops {
+	return core.Instance()
+}
+
+func (d *defaultK8sUtils) Apps() apps.Ops {
+	return apps.Instance()
+}
+
+func (d *defaultK8sUtils) Storage() storage.Ops {
+	return storage.Instance()
+}
+
+func (d *defaultK8sUtils) Batch() batch.Ops {
+	return batch.Instance()
+}
+
+func (d *defaultK8sUtils) Extensions() apiextensions.Ops {
+	return apiextensions.Instance()
+}
+
+func (d *defaultK8sUtils) AdmissionRegistration() admissionregistration.Ops {
+	return admissionregistration.Instance()
+}
+
+func (d *defaultK8sUtils) KubernetesClient() (kubernetes.Interface, error) {
+	if err := d.initClients(); err != nil {
+		return nil, err
+	}
+	return d.kubeClient, nil
+}
+
+func (d *defaultK8sUtils) ExtensionsClient() (clientset.Interface, error) {
+	if err := d.initClients(); err != nil {
+		return nil, err
+	}
+	return d.extClient, nil
+}
+
+func (d *defaultK8sUtils) DynamicClient() (dynamic.Interface, error) {
+	if err := d.initClients(); err != nil {
+		return nil, err
+	}
+	return d.dynamicClient, nil
+}
+
+// clientsK8sUtils uses the given clientsets for all the operations
+type clientsK8sUtils struct {
+	kubeClient    kubernetes.Interface
+	extClient     clientset.Interface
+	dynamicClient dynamic.Interface
+}
+
+// NewForClients returns a K8sUtils that uses the given clientsets, which can be
+// the fake clientsets from client-go for unit tests
+func NewForClients(kubeClient kubernetes.Interface, extClient clientset.Interface, dynamicClient dynamic.Interface) K8sUtils {
+	return &clientsK8sUtils{
+		kubeClient:    kubeClient,
+		extClient:     extClient,
+		dynamicClient: dynamicClient,
+	}
+}
+
+func (c *clientsK8sUtils) Core() core.Ops {
+	return core.New(c.kubeClient)
+}
+
+func (c *clientsK8sUtils) Apps() apps.Ops {
+	return apps.New(c.kubeClient.AppsV1(), c.kubeClient.CoreV1())
+}
+
+func (c *clientsK8sUtils) Storage() storage.Ops {
+	return storage.New(c.kubeClient.StorageV1())
+}
+
+func (c *clientsK8sUtils) Batch() batch.Ops {
+	return batch.New(c.kubeClient.BatchV1(), c.kubeClient.BatchV1beta1())
+}
+
+func (c *clientsK8sUtils) Extensions() apiextensions.Ops {
+	return apiextensions.New(c.extClient)
+}
+
+func (c *clientsK8sUtils) AdmissionRegistration() admissionregistration.Ops {
+	return admissionregistration.New(c.kubeClient.AdmissionregistrationV1beta1(), c.kubeClient.AdmissionregistrationV1())
+}
+
+func (c *clientsK8sUtils) KubernetesClient() (kubernetes.Interface, error) {
+	return c.kubeClient, nil
+}
+
+func (c *clientsK8sUtils) ExtensionsClient() (clientset.Interface, error) {
+	return c.extClient, nil
+}
+
+func (c *clientsK8sUtils) DynamicClient() (dynamic.Interface, error) {
+	return c.dynamicClient, nil
+}
+
+// getRestConfig returns the config used to talk to the cluster. Like the
+// sched-ops clients it uses KUBECONFIG if set and falls back to the in-cluster
+// service account otherwise.
+func getRestConfig() (*rest.Config, error) {
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if len(kubeconfig) > 0 {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+// getExtensionsClient returns an apiextensions clientset for operations that
+// aren't exposed through Instance().Extensions()
+func getExtensionsClient() (clientset.Interface, error) {
+	return Instance().ExtensionsClient()
+}
+
+// getDynamicClient returns a dynamic client for operations on resources that
+// don't have typed clients in this package, like CSI snapshots and stork CRs
+func getDynamicClient() (dynamic.Interface, error) {
+	return Instance().DynamicClient()
+}
+
+// getKubernetesClient returns a kubernetes clientset for operations that aren't
+// exposed through Instance().Core()
+func getKubernetesClient() (kubernetes.Interface, error) {
+	return Instance().KubernetesClient()
+}
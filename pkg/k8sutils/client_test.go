@@ -0,0 +1,258 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/portworx/sched-ops/k8s/apiextensions"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// setFakeInstance makes the functions in this package use fake clientsets with
+// the given objects till the test ends
+func setFakeInstance(t *testing.T, kubeObjects []runtime.Object, extObjects []runtime.Object) {
+	SetInstance(NewForClients(
+		kubernetesfake.NewSimpleClientset(kubeObjects...),
+		apiextensionsfake.NewSimpleClientset(extObjects...),
+		nil,
+	))
+	t.Cleanup(func() { SetInstance(nil) })
+}
+
+func TestGetPVCsForGroupSnapshotWithFakeClients(t *testing.T) {
+	newLabeledPVC := func(name string, phase v1.PersistentVolumeClaimPhase, labels map[string]string) runtime.Object {
+		pvc := newPVC("ns1", name, "pv-"+name)
+		pvc.Labels = labels
+		pvc.Status.Phase = phase
+		return &pvc
+	}
+	mysql := map[string]string{"app": "mysql"}
+	ns1 := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}}
+
+	testCases := []struct {
+		name        string
+		objects     []runtime.Object
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name: "bound PVCs matching the labels",
+			objects: []runtime.Object{
+				ns1,
+				newLabeledPVC("pvc1", v1.ClaimBound, mysql),
+				newLabeledPVC("pvc2", v1.ClaimBound, mysql),
+				newLabeledPVC("pvc3", v1.ClaimBound, map[string]string{"app": "nginx"}),
+			},
+			expected: []string{"pvc1", "pvc2"},
+		},
+		{
+			name: "pending PVC",
+			objects: []runtime.Object{
+				ns1,
+				newLabeledPVC("pvc1", v1.ClaimBound, mysql),
+				newLabeledPVC("pvc2", v1.ClaimPending, mysql),
+			},
+			expectedErr: "PVC: [ns1] pvc2 is still in Pending phase. Group snapshot will trigger after all PVCs are bound",
+		},
+		{
+			name:        "no matching PVCs",
+			objects:     []runtime.Object{ns1, newLabeledPVC("pvc1", v1.ClaimBound, map[string]string{"app": "nginx"})},
+			expectedErr: "found no PVCs for group snapshot with given label selectors: map[app:mysql]",
+		},
+		{
+			name:        "empty namespace",
+			objects:     []runtime.Object{ns1},
+			expectedErr: "found no PVCs for group snapshot with given label selectors: map[app:mysql]",
+		},
+		{
+			name:        "missing namespace",
+			objects:     []runtime.Object{newLabeledPVC("pvc1", v1.ClaimBound, mysql)},
+			expectedErr: "namespace \"ns1\" does not exist",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setFakeInstance(t, tc.objects, nil)
+			pvcs, err := GetPVCsForGroupSnapshot("ns1", mysql)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			names := make([]string, 0, len(pvcs))
+			for _, pvc := range pvcs {
+				names = append(names, pvc.Name)
+			}
+			require.ElementsMatch(t, tc.expected, names)
+		})
+	}
+}
+
+func TestGetUnboundPVCsWithFakeClients(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc1.Labels = map[string]string{"app": "mysql"}
+	pvc1.Status.Phase = v1.ClaimBound
+	pvc2 := newPVC("ns1", "pvc2", "")
+	pvc2.Labels = map[string]string{"app": "mysql"}
+	pvc2.Status.Phase = v1.ClaimPending
+
+	setFakeInstance(t, []runtime.Object{&pvc1, &pvc2}, nil)
+	_, err := GetUnboundPVCs("ns1", map[string]string{"app": "mysql"})
+	require.EqualError(t, err, "namespace \"ns1\" does not exist")
+	require.True(t, IsNamespaceNotFound(err))
+
+	setFakeInstance(t, []runtime.Object{&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}}, &pvc1, &pvc2}, nil)
+	pvcs, err := GetUnboundPVCs("ns1", map[string]string{"app": "mysql"})
+	require.NoError(t, err)
+	require.Len(t, pvcs, 1)
+	require.Equal(t, "pvc2", pvcs[0].Name)
+}
+
+func TestGetStorkDeploymentReplicasWithFakeClients(t *testing.T) {
+	newDeployment := func(replicas *int32) runtime.Object {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: StorkDeploymentName, Namespace: "kube-system"},
+			Spec:       appsv1.DeploymentSpec{Replicas: replicas},
+		}
+	}
+	three := int32(3)
+
+	testCases := []struct {
+		name      string
+		objects   []runtime.Object
+		expected  int32
+		expectErr bool
+	}{
+		{name: "replicas set", objects: []runtime.Object{newDeployment(&three)}, expected: 3},
+		{name: "replicas defaulted", objects: []runtime.Object{newDeployment(nil)}, expected: 1},
+		{name: "deployment missing", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setFakeInstance(t, tc.objects, nil)
+			replicas, err := GetStorkDeploymentReplicas("kube-system")
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, replicas)
+		})
+	}
+}
+
+func TestDeleteCRDWithFakeClients(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: testCRDName}}
+	setFakeInstance(t, nil, []runtime.Object{crd})
+
+	require.NoError(t, DeleteCRD(testCRDName))
+	client, err := getExtensionsClient()
+	require.NoError(t, err)
+	_, err = client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), testCRDName, metav1.GetOptions{})
+	require.True(t, errors.IsNotFound(err), "Expected CRD to be deleted")
+
+	require.NoError(t, DeleteCRD(testCRDName), "Expected deleting a missing CRD to be a no-op")
+}
+
+func TestEnsureNamespaceExists(t *testing.T) {
+	setFakeInstance(t, []runtime.Object{&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}}}, nil)
+
+	require.NoError(t, EnsureNamespaceExists("ns1"))
+	err := EnsureNamespaceExists("ns2")
+	require.EqualError(t, err, "namespace \"ns2\" does not exist")
+	require.True(t, IsNamespaceNotFound(err))
+	require.False(t, IsNamespaceNotFound(errors.NewNotFound(v1.Resource("namespaces"), "ns2")))
+}
+
+func TestSetConfigCachesClients(t *testing.T) {
+	SetConfig(&rest.Config{Host: "https://127.0.0.1:6443", QPS: 50, Burst: 75})
+	t.Cleanup(func() { SetInstance(nil) })
+
+	kubeClient, err := getKubernetesClient()
+	require.NoError(t, err)
+	again, err := getKubernetesClient()
+	require.NoError(t, err)
+	require.True(t, kubeClient == again, "Expected the kubernetes client to be created once")
+
+	extClient, err := getExtensionsClient()
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:6443", getClientHost(extClient), "Expected the client to use the given config")
+
+	SetConfig(&rest.Config{Host: "https://127.0.0.2:6443"})
+	other, err := getKubernetesClient()
+	require.NoError(t, err)
+	require.False(t, kubeClient == other, "Expected the clients to be created again for a new config")
+}
+
+func TestGetStorkPodNamespaceStrict(t *testing.T) {
+	setFakeInstance(t, nil, nil)
+	_, err := GetStorkPodNamespaceStrict()
+	require.Equal(t, errStorkNamespaceEmpty, err, "Expected an error when there are no stork pods")
+
+	setFakeInstance(t, []runtime.Object{&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "stork-1",
+		Namespace: "portworx",
+		Labels:    map[string]string{"name": "stork"},
+	}}}, nil)
+	ns, err := GetStorkPodNamespaceStrict()
+	require.NoError(t, err)
+	require.Equal(t, "portworx", ns)
+	ns, err = GetStorkPodNamespace()
+	require.NoError(t, err)
+	require.Equal(t, "portworx", ns)
+
+	kubeClient := kubernetesfake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	SetInstance(NewForClients(kubeClient, nil, nil))
+	_, err = GetStorkPodNamespace()
+	require.EqualError(t, err, "connection refused", "Expected API errors to be returned without falling back")
+}
+
+func TestRegisterStorkCRDs(t *testing.T) {
+	resource := apiextensions.CustomResource{
+		Name:    "migration",
+		Plural:  "migrations",
+		Group:   "stork.libopenstorage.org",
+		Version: "v1alpha1",
+		Scope:   apiextensionsv1beta1.NamespaceScoped,
+		Kind:    "Migration",
+	}
+	established := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}
+	extClient := newFakeCRDClient([]string{apiextensionsv1.SchemeGroupVersion.String()}, established)
+	SetInstance(NewForClients(kubernetesfake.NewSimpleClientset(), extClient, nil))
+	t.Cleanup(func() { SetInstance(nil) })
+
+	require.NoError(t, RegisterStorkCRDs([]apiextensions.CustomResource{resource}))
+	crd, err := extClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), testCRDName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "Migration", crd.Spec.Names.Kind, "Expected the existing CRD to be updated")
+
+	setFakeInstance(t, nil, []runtime.Object{established.DeepCopy()})
+	err = RegisterStorkCRDs([]apiextensions.CustomResource{resource})
+	require.Error(t, err, "Expected an error when the apiextensions API isn't served")
+	require.Contains(t, err.Error(), "registered CRDs: []")
+}
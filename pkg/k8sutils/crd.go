@@ -0,0 +1,816 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portworx/sched-ops/k8s/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// maxRemainingInstances is the number of remaining custom resources listed when
+// waiting for a CRD to be deleted times out
+const maxRemainingInstances = 10
+
+var (
+	// crdAPIVersionCache holds the apiextensions version served by each server,
+	// keyed by the host of the server
+	crdAPIVersionCache     = make(map[string]string)
+	crdAPIVersionCacheLock sync.Mutex
+)
+
+// storkCRDGroupSuffixes are the API group suffixes of the CRDs owned by stork
+var storkCRDGroupSuffixes = []string{
+	"libopenstorage.org",
+}
+
+// CRDStatus describes the health of a CRD
+type CRDStatus struct {
+	// Name of the CRD
+	Name string
+	// Group of the CRD
+	Group string
+	// Versions served by the CRD
+	Versions []string
+	// Established is true if the CRD has the Established condition set
+	Established bool
+	// Terminating is true if the CRD is being deleted
+	Terminating bool
+}
+
+// GetCRDStorageVersion returns the version of the given CRD that is marked as
+// the storage version
+func GetCRDStorageVersion(client *clientset.Clientset, crdName string) (string, error) {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name, nil
+		}
+	}
+	return "", fmt.Errorf("CRD %s does not have a storage version", crdName)
+}
+
+// ValidateCRDStorageVersion returns an error if the storage version of the given
+// CRD is not the expected version
+func ValidateCRDStorageVersion(client *clientset.Clientset, crdName, expected string) error {
+	version, err := GetCRDStorageVersion(client, crdName)
+	if err != nil {
+		return err
+	}
+	if version != expected {
+		return fmt.Errorf("storage version for CRD %s is %s, expected %s", crdName, version, expected)
+	}
+	return nil
+}
+
+// GetCRDStoredVersions returns the versions in status.storedVersions of the given
+// CRD. Objects may still be persisted in any of these versions, so a version can
+// only be removed from the CRD once it has been migrated off and dropped from
+// this list.
+func GetCRDStoredVersions(client *clientset.Clientset, crdName string) ([]string, error) {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return crd.Status.StoredVersions, nil
+}
+
+// GetCommonCRDVersion returns a version of the given CRD that is served on both
+// the local and remote clusters. The storage version of the local CRD is
+// preferred if the remote cluster serves it, otherwise the first common version
+// in the order of the local CRD is returned. An error is returned if there is no
+// common version.
+func GetCommonCRDVersion(localClient, remoteClient *clientset.Clientset, crdName string) (string, error) {
+	localCRD, err := localClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting CRD %s on local cluster: %v", crdName, err)
+	}
+	remoteCRD, err := remoteClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting CRD %s on remote cluster: %v", crdName, err)
+	}
+
+	remoteVersions := make(map[string]bool)
+	for _, version := range remoteCRD.Spec.Versions {
+		if version.Served {
+			remoteVersions[version.Name] = true
+		}
+	}
+	common := ""
+	for _, version := range localCRD.Spec.Versions {
+		if !version.Served || !remoteVersions[version.Name] {
+			continue
+		}
+		if version.Storage {
+			return version.Name, nil
+		}
+		if common == "" {
+			common = version.Name
+		}
+	}
+	if common == "" {
+		return "", fmt.Errorf("CRD %s does not have a version served on both the local and remote clusters", crdName)
+	}
+	return common, nil
+}
+
+// ValidateCRDConversionWebhook checks that the given CRD is configured to use a
+// conversion webhook and that the webhook can be reached, i.e. the service it
+// references exists and a caBundle has been set
+func ValidateCRDConversionWebhook(client *clientset.Clientset, crdName string) error {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	conversion := crd.Spec.Conversion
+	if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter {
+		return fmt.Errorf("CRD %s does not use a conversion webhook", crdName)
+	}
+	if conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+		return fmt.Errorf("conversion webhook for CRD %s does not have a client config", crdName)
+	}
+
+	clientConfig := conversion.Webhook.ClientConfig
+	if clientConfig.Service != nil {
+		service := clientConfig.Service
+		if _, err := Instance().Core().GetService(service.Name, service.Namespace); err != nil {
+			return fmt.Errorf("error getting service [%s] %s for conversion webhook of CRD %s: %v",
+				service.Namespace, service.Name, crdName, err)
+		}
+	} else if clientConfig.URL == nil || *clientConfig.URL == "" {
+		return fmt.Errorf("conversion webhook for CRD %s does not have a service or URL", crdName)
+	}
+	if len(clientConfig.CABundle) == 0 {
+		return fmt.Errorf("conversion webhook for CRD %s does not have a caBundle", crdName)
+	}
+	return nil
+}
+
+// isCRDEstablished returns true if the given CRD has the Established condition set
+func isCRDEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Established && cond.Status == apiextensionsv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCRDVersion waits till the given CRD is established and serves the
+// given version. Unlike ValidateCRDV1, which only checks that the CRD is
+// established, this catches CRDs that are still being migrated to the version
+// that the clients need.
+func ValidateCRDVersion(client *clientset.Clientset, crdName, version string, timeout time.Duration) error {
+	interval := retryInterval
+	if interval > timeout {
+		interval = timeout
+	}
+	return validateCRDVersion(client, crdName, version, timeout, interval)
+}
+
+func validateCRDVersion(client clientset.Interface, crdName, version string, timeout, interval time.Duration) error {
+	if err := validatePollInterval(timeout, interval); err != nil {
+		return err
+	}
+	var crd *apiextensionsv1.CustomResourceDefinition
+	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
+		var err error
+		crd, err = client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return isCRDEstablished(crd) && isCRDVersionServed(crd, version), nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+	if crd == nil {
+		return fmt.Errorf("CRD %s not found after %v", crdName, timeout)
+	}
+	if !isCRDVersionServed(crd, version) {
+		return fmt.Errorf("version %s of CRD %s is not served after %v, served versions: [%s]",
+			version, crdName, timeout, strings.Join(getServedCRDVersions(crd), ", "))
+	}
+	return fmt.Errorf("CRD %s is not established after %v", crdName, timeout)
+}
+
+// isCRDVersionServed returns true if the given version is in the spec of the CRD
+// and is served
+func isCRDVersionServed(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	for _, v := range crd.Spec.Versions {
+		if v.Name == version {
+			return v.Served
+		}
+	}
+	return false
+}
+
+// getServedCRDVersions returns the versions served by the given CRD
+func getServedCRDVersions(crd *apiextensionsv1.CustomResourceDefinition) []string {
+	versions := make([]string, 0, len(crd.Spec.Versions))
+	for _, v := range crd.Spec.Versions {
+		if v.Served {
+			versions = append(versions, v.Name)
+		}
+	}
+	return versions
+}
+
+// errCRDWatchExpired is returned by waitForEstablishedEvent when the resource
+// version the watch was started from is too old
+var errCRDWatchExpired = fmt.Errorf("resource version of the CRD watch expired")
+
+// WatchCRDEstablished waits till the given CRD has the Established condition set.
+// Instead of polling, a watch is set up on the CRD so that this returns as soon
+// as the condition flips. An initial Get catches CRDs that are already
+// established before the watch starts. If the resource version the watch is
+// resumed from has expired, the CRD is read again and watched from its current
+// version.
+func WatchCRDEstablished(ctx context.Context, client *clientset.Clientset, crdName string) error {
+	return watchCRDEstablished(ctx, client, crdName)
+}
+
+func watchCRDEstablished(ctx context.Context, client clientset.Interface, crdName string) error {
+	crds := client.ApiextensionsV1().CustomResourceDefinitions()
+	resourceVersion, established, err := getCRDEstablishedVersion(ctx, client, crdName)
+	if err != nil || established {
+		return err
+	}
+
+	for {
+		watcher, err := crds.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", crdName).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			return fmt.Errorf("error watching CRD %s: %v", crdName, err)
+		}
+		established, lastVersion, err := waitForEstablishedEvent(ctx, watcher, crdName)
+		watcher.Stop()
+		if err == errCRDWatchExpired {
+			resourceVersion, established, err = getCRDEstablishedVersion(ctx, client, crdName)
+			if err != nil || established {
+				return err
+			}
+			continue
+		}
+		if err != nil || established {
+			return err
+		}
+		// The watch was closed by the server, resume from the last seen version
+		if lastVersion != "" {
+			resourceVersion = lastVersion
+		}
+	}
+}
+
+// getCRDEstablishedVersion gets the given CRD, returning whether it is
+// established and the resource version to watch it from. An empty resource
+// version is returned if the CRD doesn't exist yet.
+func getCRDEstablishedVersion(ctx context.Context, client clientset.Interface, crdName string) (string, bool, error) {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return crd.ResourceVersion, isCRDEstablished(crd), nil
+}
+
+// waitForEstablishedEvent reads events from the watch till the CRD is
+// established, the watch is closed or the context is done. The resource version
+// of the last event seen is returned so that the watch can be resumed.
+// errCRDWatchExpired is returned if the watch failed because its resource
+// version has expired.
+func waitForEstablishedEvent(ctx context.Context, watcher watch.Interface, crdName string) (bool, string, error) {
+	lastVersion := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return false, lastVersion, fmt.Errorf("timed out waiting for CRD %s to be established: %v", crdName, ctx.Err())
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, lastVersion, nil
+			}
+			switch event.Type {
+			case watch.Error:
+				err := errors.FromObject(event.Object)
+				if errors.IsResourceExpired(err) || errors.IsGone(err) {
+					return false, "", errCRDWatchExpired
+				}
+				return false, lastVersion, fmt.Errorf("error watching CRD %s: %v", crdName, err)
+			case watch.Deleted:
+				return false, lastVersion, fmt.Errorf("CRD %s was deleted while waiting for it to be established", crdName)
+			}
+			crd, ok := event.Object.(*apiextensionsv1.CustomResourceDefinition)
+			if !ok {
+				continue
+			}
+			lastVersion = crd.ResourceVersion
+			if isCRDEstablished(crd) {
+				return true, lastVersion, nil
+			}
+		}
+	}
+}
+
+// ListStorkCRDs returns the status of every CRD that belongs to one of the stork
+// API groups, sorted by name
+func ListStorkCRDs(client *clientset.Clientset) ([]CRDStatus, error) {
+	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]CRDStatus, 0)
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		if !isStorkCRDGroup(crd.Spec.Group) {
+			continue
+		}
+		versions := make([]string, 0, len(crd.Spec.Versions))
+		for _, version := range crd.Spec.Versions {
+			if version.Served {
+				versions = append(versions, version.Name)
+			}
+		}
+		statuses = append(statuses, CRDStatus{
+			Name:        crd.Name,
+			Group:       crd.Spec.Group,
+			Versions:    versions,
+			Established: isCRDEstablished(crd),
+			Terminating: isCRDTerminating(crd),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
+
+// ValidateStorkCRDGroups returns an error if any of the kinds served under the
+// expected stork group is also served by a CRD in another stork group, like a
+// CRD left behind by an install that used an older group. The error includes the
+// names of the offending CRDs.
+func ValidateStorkCRDGroups(client *clientset.Clientset, expectedGroup string) error {
+	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	storkKinds := make(map[string]bool)
+	for _, crd := range crds.Items {
+		if crd.Spec.Group == expectedGroup {
+			storkKinds[crd.Spec.Names.Kind] = true
+		}
+	}
+	conflicts := make([]string, 0)
+	for _, crd := range crds.Items {
+		if crd.Spec.Group == expectedGroup || !isStorkCRDGroup(crd.Spec.Group) {
+			continue
+		}
+		if storkKinds[crd.Spec.Names.Kind] {
+			conflicts = append(conflicts, crd.Name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("found CRDs for stork kinds outside of group %s: %s", expectedGroup, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// isStorkCRDGroup returns true if the given API group is owned by stork
+func isStorkCRDGroup(group string) bool {
+	for _, suffix := range storkCRDGroupSuffixes {
+		if group == suffix || strings.HasSuffix(group, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCRDTerminating returns true if the given CRD is being deleted
+func isCRDTerminating(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	if crd.DeletionTimestamp != nil {
+		return true
+	}
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Terminating && cond.Status == apiextensionsv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// isCRDV1Served returns true if the cluster serves the v1 apiextensions API. This
+// is false for clusters older than 1.16. The served version is cached like for
+// ValidateCRDAuto.
+func isCRDV1Served(client clientset.Interface) (bool, error) {
+	version, err := getServedCRDAPIVersion(client)
+	if err != nil {
+		return false, err
+	}
+	return version == apiextensionsv1.SchemeGroupVersion.Version, nil
+}
+
+// ValidateCRDAuto validates the given CRD using the v1 apiextensions API if the
+// server serves it, falling back to v1beta1 for older clusters. The served
+// version is discovered once per server host and cached, so clients for the
+// same cluster share the result. Fake clients without a host are not cached.
+func ValidateCRDAuto(client clientset.Interface, crdName string) error {
+	return validateCRDAuto(client, crdName, crdTimeout, retryInterval)
+}
+
+func validateCRDAuto(client clientset.Interface, crdName string, timeout, interval time.Duration) error {
+	version, err := getServedCRDAPIVersion(client)
+	if err != nil {
+		return err
+	}
+	if version == apiextensionsv1.SchemeGroupVersion.Version {
+		return validateCRDV1(context.Background(), client, crdName, CRDValidationOptions{}, PollConfig{Timeout: timeout, Interval: interval})
+	}
+	return validateCRDV1beta1(context.Background(), client, crdName, CRDValidationOptions{}, PollConfig{Timeout: timeout, Interval: interval})
+}
+
+// CreateAndValidateCRD creates the CRD for the given custom resource like
+// CreateCRD and waits till it is established like ValidateCRDAuto, so that the
+// custom resource can be used as soon as it returns. A CRD that already exists
+// is validated the same way.
+func CreateAndValidateCRD(resource apiextensions.CustomResource, timeout time.Duration) error {
+	crdName := fmt.Sprintf("%s.%s", resource.Plural, resource.Group)
+	if err := CreateCRD(resource); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating CRD %s: %v", crdName, err)
+	}
+	client, err := getExtensionsClient()
+	if err != nil {
+		return err
+	}
+	interval := retryInterval
+	if interval > timeout {
+		interval = timeout
+	}
+	if err := validateCRDAuto(client, crdName, timeout, interval); err != nil {
+		return fmt.Errorf("CRD %s was created but is not established: %v", crdName, err)
+	}
+	return nil
+}
+
+// IsCRDAvailable returns true if the given CRD exists and is established, using
+// the apiextensions version served by the server. Unlike ValidateCRDAuto it
+// checks the CRD once instead of waiting for it.
+func IsCRDAvailable(client clientset.Interface, crdName string) (bool, error) {
+	version, err := getServedCRDAPIVersion(client)
+	if err != nil {
+		return false, err
+	}
+	if version == apiextensionsv1.SchemeGroupVersion.Version {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return isCRDEstablished(crd), nil
+	}
+
+	crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1beta1.Established {
+			return cond.Status == apiextensionsv1beta1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateCRDs validates the given CRDs concurrently like ValidateCRDAuto, with
+// the timeout shared by all of them. The returned error lists every CRD that
+// failed validation.
+func ValidateCRDs(client clientset.Interface, crdNames []string, timeout time.Duration) error {
+	interval := retryInterval
+	if interval > timeout {
+		interval = timeout
+	}
+	return validateCRDs(client, crdNames, timeout, interval)
+}
+
+func validateCRDs(client clientset.Interface, crdNames []string, timeout, interval time.Duration) error {
+	failed := getCRDValidationFailures(client, crdNames, timeout, interval)
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to validate CRDs: %s", formatCRDFailures(failed))
+}
+
+// getCRDValidationFailures validates the given CRDs concurrently and returns the
+// errors of the ones that failed, keyed by their names
+func getCRDValidationFailures(client clientset.Interface, crdNames []string, timeout, interval time.Duration) map[string]error {
+	failed := make(map[string]error)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, crdName := range crdNames {
+		wg.Add(1)
+		go func(crdName string) {
+			defer wg.Done()
+			if err := validateCRDAuto(client, crdName, timeout, interval); err != nil {
+				lock.Lock()
+				failed[crdName] = err
+				lock.Unlock()
+			}
+		}(crdName)
+	}
+	wg.Wait()
+	return failed
+}
+
+// formatCRDFailures returns the given CRD errors sorted by the CRD names
+func formatCRDFailures(failed map[string]error) string {
+	failures := make([]string, 0, len(failed))
+	for crdName, err := range failed {
+		failures = append(failures, fmt.Sprintf("%s: %v", crdName, err))
+	}
+	sort.Strings(failures)
+	return strings.Join(failures, ", ")
+}
+
+// getServedCRDAPIVersion returns the version of the apiextensions API served by
+// the server, preferring v1 over v1beta1. The result is cached for the host of
+// the server, so clients created for the same cluster share it. The lock is
+// only held to access the cache, not during discovery, so a slow server doesn't
+// block the lookups for other servers.
+func getServedCRDAPIVersion(client clientset.Interface) (string, error) {
+	host := getClientHost(client)
+	if host != "" {
+		crdAPIVersionCacheLock.Lock()
+		version, ok := crdAPIVersionCache[host]
+		crdAPIVersionCacheLock.Unlock()
+		if ok {
+			return version, nil
+		}
+	}
+
+	groups, err := client.Discovery().ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("error discovering %v API versions: %v", apiextensionsv1.GroupName, err)
+	}
+	served := make(map[string]bool)
+	for _, group := range groups.Groups {
+		if group.Name != apiextensionsv1.GroupName {
+			continue
+		}
+		for _, version := range group.Versions {
+			served[version.Version] = true
+		}
+	}
+	for _, version := range []string{apiextensionsv1.SchemeGroupVersion.Version, apiextensionsv1beta1.SchemeGroupVersion.Version} {
+		if served[version] {
+			if host != "" {
+				crdAPIVersionCacheLock.Lock()
+				crdAPIVersionCache[host] = version
+				crdAPIVersionCacheLock.Unlock()
+			}
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("server does not serve the v1 or v1beta1 %v API", apiextensionsv1.GroupName)
+}
+
+// getClientHost returns the host of the server the client talks to, or an empty
+// string if it isn't known, like for fake clients
+func getClientHost(client clientset.Interface) string {
+	restClient, ok := client.Discovery().RESTClient().(*rest.RESTClient)
+	if !ok || restClient == nil {
+		return ""
+	}
+	return restClient.Get().URL().Host
+}
+
+// crdExists returns true if the given CRD exists, using the v1 or v1beta1
+// apiextensions API
+func crdExists(client clientset.Interface, useV1 bool, crdName string) (bool, error) {
+	var err error
+	if useV1 {
+		_, err = client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	} else {
+		_, err = client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteCRD deletes the given CRD. It is a no-op if the CRD doesn't exist.
+func DeleteCRD(crdName string) error {
+	client, err := getExtensionsClient()
+	if err != nil {
+		return err
+	}
+	return deleteCRD(client, true, crdName)
+}
+
+// DeleteCRDAndWait deletes the given CRD and waits till it has been removed. The
+// removal can be blocked by finalizers on the CRD and by custom resources that
+// still exist.
+func DeleteCRDAndWait(crdName string, timeout time.Duration) error {
+	client, err := getExtensionsClient()
+	if err != nil {
+		return err
+	}
+	return deleteCRDAndWait(client, crdName, timeout, retryInterval)
+}
+
+// deleteCRD deletes the given CRD using the v1 or v1beta1 apiextensions API
+func deleteCRD(client clientset.Interface, useV1 bool, crdName string) error {
+	var err error
+	if useV1 {
+		err = client.ApiextensionsV1().CustomResourceDefinitions().Delete(context.TODO(), crdName, metav1.DeleteOptions{})
+	} else {
+		err = client.ApiextensionsV1beta1().CustomResourceDefinitions().Delete(context.TODO(), crdName, metav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting CRD %s: %v", crdName, err)
+	}
+	return nil
+}
+
+func deleteCRDAndWait(client clientset.Interface, crdName string, timeout, interval time.Duration) error {
+	if err := validatePollInterval(timeout, interval); err != nil {
+		return err
+	}
+	useV1, err := isCRDV1Served(client)
+	if err != nil {
+		return err
+	}
+	if err := deleteCRD(client, useV1, crdName); err != nil {
+		return err
+	}
+	return waitForCRDDeletedWithDetails(client, useV1, crdName, timeout, interval)
+}
+
+// WaitForCRDDeleted waits till the given CRD has been deleted. The v1
+// apiextensions API is used if the cluster serves it, falling back to v1beta1
+// for older clusters.
+func WaitForCRDDeleted(client *clientset.Clientset, crdName string, timeout time.Duration) error {
+	useV1, err := isCRDV1Served(client)
+	if err != nil {
+		return err
+	}
+	return waitForCRDDeleted(client, useV1, crdName, timeout, retryInterval)
+}
+
+// WaitForCRDDeletedWithDetails is like WaitForCRDDeleted, but on timeout the
+// returned error lists the finalizers of the CRD and the custom resources of the
+// CRD that still exist, which are usually the reason the deletion is blocked
+func WaitForCRDDeletedWithDetails(client *clientset.Clientset, crdName string, timeout time.Duration) error {
+	useV1, err := isCRDV1Served(client)
+	if err != nil {
+		return err
+	}
+	return waitForCRDDeletedWithDetails(client, useV1, crdName, timeout, retryInterval)
+}
+
+func waitForCRDDeletedWithDetails(client clientset.Interface, useV1 bool, crdName string, timeout, interval time.Duration) error {
+	err := waitForCRDDeleted(client, useV1, crdName, timeout, interval)
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	details := make([]string, 0)
+	if finalizers, err := getCRDFinalizers(client, useV1, crdName); err == nil && len(finalizers) > 0 {
+		details = append(details, "blocked by finalizers: "+strings.Join(finalizers, ", "))
+	}
+	remaining, listErr := getRemainingCustomResources(client, useV1, crdName)
+	if listErr != nil {
+		details = append(details, fmt.Sprintf("error listing remaining resources: %v", listErr))
+	} else if len(remaining) > 0 {
+		details = append(details, "remaining resources: "+strings.Join(remaining, ", "))
+	}
+	if len(details) == 0 {
+		return fmt.Errorf("timed out after %v waiting for CRD %s to be deleted", timeout, crdName)
+	}
+	return fmt.Errorf("timed out after %v waiting for CRD %s to be deleted, %s", timeout, crdName, strings.Join(details, "; "))
+}
+
+// waitForCRDDeleted polls till Get for the CRD returns NotFound
+func waitForCRDDeleted(client clientset.Interface, useV1 bool, crdName string, timeout, interval time.Duration) error {
+	return wait.PollImmediate(interval, timeout, func() (bool, error) {
+		exists, err := crdExists(client, useV1, crdName)
+		if err != nil {
+			return false, err
+		}
+		return !exists, nil
+	})
+}
+
+// getCRDFinalizers returns the finalizers of the given CRD
+func getCRDFinalizers(client clientset.Interface, useV1 bool, crdName string) ([]string, error) {
+	if useV1 {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return crd.Finalizers, nil
+	}
+	crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return crd.Finalizers, nil
+}
+
+// getCRDResource returns the resource served by the given CRD and whether it is
+// namespaced
+func getCRDResource(client clientset.Interface, useV1 bool, crdName string) (schema.GroupVersionResource, bool, error) {
+	if useV1 {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+		if err != nil {
+			return schema.GroupVersionResource{}, false, err
+		}
+		for _, version := range crd.Spec.Versions {
+			if version.Served {
+				return schema.GroupVersionResource{
+					Group:    crd.Spec.Group,
+					Version:  version.Name,
+					Resource: crd.Spec.Names.Plural,
+				}, crd.Spec.Scope == apiextensionsv1.NamespaceScoped, nil
+			}
+		}
+		return schema.GroupVersionResource{}, false, fmt.Errorf("CRD %s does not have a served version", crdName)
+	}
+
+	crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	version := crd.Spec.Version
+	for _, v := range crd.Spec.Versions {
+		if v.Served {
+			version = v.Name
+			break
+		}
+	}
+	if version == "" {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("CRD %s does not have a served version", crdName)
+	}
+	return schema.GroupVersionResource{
+		Group:    crd.Spec.Group,
+		Version:  version,
+		Resource: crd.Spec.Names.Plural,
+	}, crd.Spec.Scope == apiextensionsv1beta1.NamespaceScoped, nil
+}
+
+// getRemainingCustomResources returns up to maxRemainingInstances custom
+// resources of the given CRD as <namespace>/<name>, or <name> for cluster
+// scoped resources
+func getRemainingCustomResources(client clientset.Interface, useV1 bool, crdName string) ([]string, error) {
+	gvr, namespaced, err := getCRDResource(client, useV1, crdName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	list, err := dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{Limit: maxRemainingInstances})
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		if namespaced {
+			remaining = append(remaining, item.GetNamespace()+"/"+item.GetName())
+		} else {
+			remaining = append(remaining, item.GetName())
+		}
+	}
+	return remaining, nil
+}
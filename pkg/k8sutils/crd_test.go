@@ -0,0 +1,455 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/portworx/sched-ops/k8s/apiextensions"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testCRDName = "migrations.stork.libopenstorage.org"
+
+func newFakeCRDClient(groupVersions []string, objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	resources := make([]*metav1.APIResourceList, 0)
+	for _, groupVersion := range groupVersions {
+		resources = append(resources, &metav1.APIResourceList{GroupVersion: groupVersion})
+	}
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = resources
+	return client
+}
+
+func getDiscoveryCalls(client *fake.Clientset) int {
+	calls := 0
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "group" {
+			calls++
+		}
+	}
+	return calls
+}
+
+func TestValidateCRDAutoV1(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}
+	client := newFakeCRDClient([]string{
+		apiextensionsv1.SchemeGroupVersion.String(),
+		apiextensionsv1beta1.SchemeGroupVersion.String(),
+	}, crd)
+
+	require.NoError(t, ValidateCRDAuto(client, testCRDName))
+	require.NoError(t, ValidateCRDAuto(client, testCRDName))
+	require.Equal(t, 2, getDiscoveryCalls(client), "Expected discovery to not be cached for a client without a host")
+}
+
+func TestValidateCRDAutoV1beta1(t *testing.T) {
+	crd := &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1beta1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1beta1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1beta1.Established, Status: apiextensionsv1beta1.ConditionTrue},
+			},
+		},
+	}
+	client := newFakeCRDClient([]string{apiextensionsv1beta1.SchemeGroupVersion.String()}, crd)
+
+	require.NoError(t, ValidateCRDAuto(client, testCRDName))
+	version, err := getServedCRDAPIVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, apiextensionsv1beta1.SchemeGroupVersion.Version, version)
+}
+
+func TestGetServedCRDAPIVersionCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			requests++
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		case "/apis":
+			fmt.Fprint(w, `{"kind":"APIGroupList","groups":[{"name":"apiextensions.k8s.io","versions":[{"groupVersion":"apiextensions.k8s.io/v1","version":"v1"}]}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		client, err := clientset.NewForConfig(&rest.Config{Host: server.URL})
+		require.NoError(t, err)
+		version, err := getServedCRDAPIVersion(client)
+		require.NoError(t, err)
+		require.Equal(t, apiextensionsv1.SchemeGroupVersion.Version, version)
+	}
+	require.Equal(t, 1, requests, "Expected the version to be cached for the host across clients")
+}
+
+func TestValidateCRDAutoNotServed(t *testing.T) {
+	client := newFakeCRDClient([]string{"apps/v1"})
+	require.Error(t, ValidateCRDAuto(client, testCRDName), "Expected error when apiextensions isn't served")
+}
+
+func TestMergeCRD(t *testing.T) {
+	preserve := true
+	existing := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testCRDName,
+			Labels: map[string]string{"app": "custom"},
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Scope: apiextensionsv1.NamespaceScoped,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Migration", Plural: "migrations"},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name:    "v1alpha1",
+					Served:  true,
+					Storage: true,
+					AdditionalPrinterColumns: []apiextensionsv1.CustomResourceColumnDefinition{
+						{Name: "Stage", Type: "string", JSONPath: ".status.stage"},
+					},
+				},
+			},
+		},
+	}
+	desired := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testCRDName,
+			Labels: map[string]string{StorkVersionLabel: "2.8.0"},
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Scope: apiextensionsv1.NamespaceScoped,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Kind:       "Migration",
+				Plural:     "migrations",
+				Singular:   "migration",
+				ShortNames: []string{"migr"},
+			},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name:    "v1alpha1",
+					Served:  true,
+					Storage: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{
+						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{XPreserveUnknownFields: &preserve},
+					},
+				},
+			},
+		},
+	}
+
+	mergeCRD(existing, desired)
+	require.Equal(t, "custom", existing.Labels["app"])
+	require.Equal(t, "2.8.0", existing.Labels[StorkVersionLabel])
+	require.Equal(t, []string{"migr"}, existing.Spec.Names.ShortNames)
+	require.Len(t, existing.Spec.Versions, 1)
+	require.NotNil(t, existing.Spec.Versions[0].Schema)
+	require.Len(t, existing.Spec.Versions[0].AdditionalPrinterColumns, 1, "Expected printer columns to be preserved")
+
+	desired.Spec.Versions[0].Name = "v1"
+	mergeCRD(existing, desired)
+	require.Len(t, existing.Spec.Versions, 2)
+	require.False(t, existing.Spec.Versions[0].Storage, "Expected old version to no longer be the storage version")
+	require.True(t, existing.Spec.Versions[1].Storage)
+}
+
+func TestGetCRDFromResourceWithSchema(t *testing.T) {
+	resource := apiextensions.CustomResource{
+		Name:    "migration",
+		Plural:  "migrations",
+		Group:   "stork.libopenstorage.org",
+		Version: "v1alpha1",
+		Kind:    "Migration",
+	}
+
+	crd := getCRDFromResourceWithSchema(resource, nil, nil)
+	require.Equal(t, testCRDName, crd.Name)
+	schema := crd.Spec.Versions[0].Schema.OpenAPIV3Schema
+	require.NotNil(t, schema.XPreserveUnknownFields)
+	require.True(t, *schema.XPreserveUnknownFields, "Expected unknown fields to be preserved without a schema")
+	require.Nil(t, crd.Spec.Versions[0].Subresources)
+
+	spec := &apiextensionsv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": {Type: "object"},
+		},
+	}
+	subresources := &apiextensionsv1.CustomResourceSubresources{
+		Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+	}
+	crd = getCRDFromResourceWithSchema(resource, spec, subresources)
+	require.Equal(t, spec, crd.Spec.Versions[0].Schema.OpenAPIV3Schema)
+	require.Nil(t, crd.Spec.Versions[0].Schema.OpenAPIV3Schema.XPreserveUnknownFields)
+	require.NotNil(t, crd.Spec.Versions[0].Subresources.Status)
+}
+
+func TestGetMultiVersionCRDFromResource(t *testing.T) {
+	resource := apiextensions.CustomResource{
+		Name:   "migration",
+		Plural: "migrations",
+		Group:  "stork.libopenstorage.org",
+		Kind:   "Migration",
+	}
+
+	crd, err := getMultiVersionCRDFromResource(resource, []CRDVersion{
+		{Name: "v1alpha1", Served: true},
+		{Name: "v1", Served: true, Storage: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, crd.Spec.Versions, 2)
+	require.False(t, crd.Spec.Versions[0].Storage)
+	require.True(t, crd.Spec.Versions[1].Storage)
+	require.Equal(t, apiextensionsv1.NoneConverter, crd.Spec.Conversion.Strategy)
+
+	_, err = getMultiVersionCRDFromResource(resource, []CRDVersion{
+		{Name: "v1alpha1", Served: true},
+		{Name: "v1", Served: true},
+	})
+	require.Error(t, err, "Expected error without a storage version")
+
+	_, err = getMultiVersionCRDFromResource(resource, []CRDVersion{
+		{Name: "v1alpha1", Served: true, Storage: true},
+		{Name: "v1", Served: true, Storage: true},
+	})
+	require.Error(t, err, "Expected error with multiple storage versions")
+
+	_, err = getMultiVersionCRDFromResource(resource, nil)
+	require.Error(t, err, "Expected error without versions")
+}
+
+func TestDeleteCRDAndWait(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+	}
+	v1 := []string{apiextensionsv1.SchemeGroupVersion.String()}
+	client := newFakeCRDClient(v1, crd)
+	require.NoError(t, deleteCRDAndWait(client, testCRDName, time.Second, 10*time.Millisecond))
+	require.NoError(t, deleteCRDAndWait(client, testCRDName, time.Second, 10*time.Millisecond),
+		"Expected no error deleting a CRD that doesn't exist")
+
+	v1beta1CRD := &apiextensionsv1beta1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: testCRDName}}
+	client = newFakeCRDClient([]string{apiextensionsv1beta1.SchemeGroupVersion.String()}, v1beta1CRD)
+	require.NoError(t, deleteCRDAndWait(client, testCRDName, time.Second, 10*time.Millisecond),
+		"Expected the CRD to be deleted with v1beta1 when v1 isn't served")
+
+	crd.Finalizers = []string{"customresourcecleanup.apiextensions.k8s.io"}
+	client = newFakeCRDClient(v1, crd)
+	client.PrependReactor("delete", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// Keep the CRD around like the API server would while it has finalizers
+		return true, nil, nil
+	})
+	err := deleteCRDAndWait(client, testCRDName, 50*time.Millisecond, 10*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blocked by finalizers: customresourcecleanup.apiextensions.k8s.io")
+
+	err = deleteCRDAndWait(client, testCRDName, 10*time.Millisecond, time.Second)
+	require.Error(t, err, "Expected error when interval is greater than timeout")
+}
+
+func TestValidateCRDs(t *testing.T) {
+	established := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}
+	notEstablished := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "backuplocations.stork.libopenstorage.org"},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionFalse, Reason: "Installing"},
+			},
+		},
+	}
+	client := newFakeCRDClient([]string{apiextensionsv1.SchemeGroupVersion.String()}, established, notEstablished)
+
+	require.NoError(t, validateCRDs(client, []string{testCRDName}, 50*time.Millisecond, 10*time.Millisecond))
+
+	err := validateCRDs(client, []string{
+		testCRDName,
+		notEstablished.Name,
+		"missing.stork.libopenstorage.org",
+	}, 50*time.Millisecond, 10*time.Millisecond)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), testCRDName+":")
+	require.Contains(t, err.Error(), notEstablished.Name+": ")
+	require.Contains(t, err.Error(), "conditions: Established=False (Installing)")
+	require.Contains(t, err.Error(), "CRD missing.stork.libopenstorage.org not found after")
+}
+
+func TestIsCRDAvailable(t *testing.T) {
+	established := &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1beta1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1beta1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1beta1.Established, Status: apiextensionsv1beta1.ConditionTrue},
+			},
+		},
+	}
+	notEstablished := &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "backuplocations.stork.libopenstorage.org"},
+	}
+	client := newFakeCRDClient([]string{apiextensionsv1beta1.SchemeGroupVersion.String()}, established, notEstablished)
+
+	available, err := IsCRDAvailable(client, testCRDName)
+	require.NoError(t, err)
+	require.True(t, available)
+
+	available, err = IsCRDAvailable(client, notEstablished.Name)
+	require.NoError(t, err)
+	require.False(t, available, "Expected CRD that isn't established to be unavailable")
+
+	available, err = IsCRDAvailable(client, "missing.stork.libopenstorage.org")
+	require.NoError(t, err)
+	require.False(t, available, "Expected missing CRD to be unavailable")
+
+	v1CRD := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}
+	client = newFakeCRDClient([]string{apiextensionsv1.SchemeGroupVersion.String()}, v1CRD)
+	available, err = IsCRDAvailable(client, testCRDName)
+	require.NoError(t, err)
+	require.True(t, available)
+}
+
+func TestWatchCRDEstablishedExpired(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName, ResourceVersion: "5"},
+	}
+	client := fake.NewSimpleClientset(crd)
+	watchers := []*watch.FakeWatcher{watch.NewFake(), watch.NewFake()}
+	watchVersions := make([]string, 0)
+	client.PrependWatchReactor("customresourcedefinitions", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watchers[len(watchVersions)]
+		watchVersions = append(watchVersions, action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+		return true, watcher, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error)
+	go func() {
+		errCh <- watchCRDEstablished(ctx, client, testCRDName)
+	}()
+
+	// The watch fails with 410 Gone, so the CRD is read again and watched from
+	// its current version instead of failing the wait
+	watchers[0].Error(&metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired})
+	established := crd.DeepCopy()
+	established.ResourceVersion = "7"
+	established.Status.Conditions = []apiextensionsv1.CustomResourceDefinitionCondition{
+		{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+	}
+	watchers[1].Modify(established)
+	require.NoError(t, <-errCh)
+	require.Equal(t, []string{"5", "5"}, watchVersions)
+}
+
+func TestGetCRDTimeoutError(t *testing.T) {
+	require.EqualError(t, getCRDTimeoutError(testCRDName, time.Minute, false, nil),
+		"CRD migrations.stork.libopenstorage.org not found after 1m0s")
+	require.EqualError(t, getCRDTimeoutError(testCRDName, time.Minute, true, nil),
+		"timed out after 1m0s waiting for CRD migrations.stork.libopenstorage.org to be established: no conditions reported")
+	conditions := []string{
+		formatCRDCondition("NamesAccepted", "True", "NoConflicts"),
+		formatCRDCondition("Established", "False", ""),
+	}
+	require.EqualError(t, getCRDTimeoutError(testCRDName, time.Minute, true, conditions),
+		"timed out after 1m0s waiting for CRD migrations.stork.libopenstorage.org to be established, "+
+			"conditions: NamesAccepted=True (NoConflicts), Established=False")
+}
+
+func TestGetCRDFromResourceWithColumns(t *testing.T) {
+	resource := apiextensions.CustomResource{
+		Name:    "migration",
+		Plural:  "migrations",
+		Group:   "stork.libopenstorage.org",
+		Version: "v1alpha1",
+		Kind:    "Migration",
+	}
+	columns := []apiextensionsv1.CustomResourceColumnDefinition{
+		{Name: "Stage", Type: "string", JSONPath: ".status.stage"},
+		{Name: "Status", Type: "string", JSONPath: ".status.status"},
+	}
+	crd, err := getCRDFromResourceWithColumns(resource, columns)
+	require.NoError(t, err)
+	require.Equal(t, columns, crd.Spec.Versions[0].AdditionalPrinterColumns)
+
+	crd, err = getCRDFromResourceWithColumns(resource, nil)
+	require.NoError(t, err)
+	require.Nil(t, crd.Spec.Versions[0].AdditionalPrinterColumns)
+
+	_, err = getCRDFromResourceWithColumns(resource, []apiextensionsv1.CustomResourceColumnDefinition{
+		{Name: "Stage", Type: "string"},
+	})
+	require.Error(t, err, "Expected error for column without jsonPath")
+
+	_, err = getCRDFromResourceWithColumns(resource, []apiextensionsv1.CustomResourceColumnDefinition{
+		{Name: "Stage", Type: "object", JSONPath: ".status.stage"},
+	})
+	require.Error(t, err, "Expected error for unsupported column type")
+}
+
+func TestValidateCRDVersion(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: testCRDName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: true, Storage: true},
+				{Name: "v1beta1", Served: false},
+			},
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+			StoredVersions: []string{"v1alpha1"},
+		},
+	}
+	client := fake.NewSimpleClientset(crd)
+
+	require.NoError(t, validateCRDVersion(client, testCRDName, "v1alpha1", 20*time.Millisecond, 10*time.Millisecond))
+
+	err := validateCRDVersion(client, testCRDName, "v1beta1", 20*time.Millisecond, 10*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "served versions: [v1alpha1]", "Expected error to list the served versions")
+
+	err = validateCRDVersion(client, testCRDName, "v1", 20*time.Millisecond, 10*time.Millisecond)
+	require.Error(t, err, "Expected error for version missing from the CRD")
+
+	err = validateCRDVersion(client, "missing.stork.libopenstorage.org", "v1alpha1", 20*time.Millisecond, 10*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not found")
+}
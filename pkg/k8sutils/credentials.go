@@ -0,0 +1,82 @@
+package k8sutils
+
+import (
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// DriverAuthCheckFunc makes a lightweight authenticated call to the storage
+// backend to check that the credentials stork has been configured with are valid
+type DriverAuthCheckFunc func() error
+
+var (
+	// driverCredentialEnvVars are the environment variables on the stork container
+	// that hold the credentials for each driver
+	driverCredentialEnvVars = map[string]string{
+		"pxd": "PX_SHARED_SECRET",
+	}
+	driverAuthCheckFuncs     = make(map[string]DriverAuthCheckFunc)
+	driverAuthCheckFuncsLock sync.RWMutex
+)
+
+// RegisterDriverAuthCheckFunc registers the function used to check the
+// credentials for the given driver
+func RegisterDriverAuthCheckFunc(driverName string, fn DriverAuthCheckFunc) {
+	driverAuthCheckFuncsLock.Lock()
+	defer driverAuthCheckFuncsLock.Unlock()
+	driverAuthCheckFuncs[driverName] = fn
+}
+
+// ValidateDriverCredentials checks that the secret referenced by the stork
+// deployment in the given namespace for the driver's credentials exists and has
+// the expected key, and then checks that the driver can authenticate with them.
+// Drivers that don't use credentials, or haven't been configured with any, only
+// run the auth check.
+func ValidateDriverCredentials(namespace, driverName string) error {
+	if envName, ok := driverCredentialEnvVars[driverName]; ok {
+		deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+		if err != nil {
+			return err
+		}
+		container, err := getStorkContainer(deploy)
+		if err != nil {
+			return err
+		}
+		if err := validateCredentialSecret(namespace, container, envName); err != nil {
+			return err
+		}
+	}
+
+	driverAuthCheckFuncsLock.RLock()
+	check, ok := driverAuthCheckFuncs[driverName]
+	driverAuthCheckFuncsLock.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := check(); err != nil {
+		return fmt.Errorf("failed to authenticate with driver %s: %v", driverName, err)
+	}
+	return nil
+}
+
+// validateCredentialSecret checks that the secret key referenced by the given
+// environment variable of the container exists and isn't empty
+func validateCredentialSecret(namespace string, container *v1.Container, envName string) error {
+	for _, env := range container.Env {
+		if env.Name != envName || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+			continue
+		}
+		ref := env.ValueFrom.SecretKeyRef
+		secret, err := Instance().Core().GetSecret(ref.Name, namespace)
+		if err != nil {
+			return fmt.Errorf("error getting credentials secret [%s] %s referenced by %s: %v", namespace, ref.Name, envName, err)
+		}
+		if len(secret.Data[ref.Key]) == 0 {
+			return fmt.Errorf("credentials secret [%s] %s does not have a value for key %s referenced by %s",
+				namespace, ref.Name, ref.Key, envName)
+		}
+	}
+	return nil
+}
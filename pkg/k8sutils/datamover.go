@@ -0,0 +1,46 @@
+package k8sutils
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DataMoverPVCNameLabel - label set by the data mover on its jobs with the name
+	// of the PVC being moved
+	DataMoverPVCNameLabel = "kdmp.portworx.com/pvc-name"
+)
+
+// GetActiveDataMoverJobsForPVCs returns the data mover jobs in the namespace that
+// are still running for any of the given PVCs, keyed by PVC name
+func GetActiveDataMoverJobsForPVCs(namespace string, pvcs []v1.PersistentVolumeClaim) (map[string]string, error) {
+	active := make(map[string]string)
+	for _, pvc := range pvcs {
+		jobs, err := Instance().Batch().ListAllJobs(namespace, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", DataMoverPVCNameLabel, pvc.Name),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing data mover jobs for PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+		}
+		for _, job := range jobs.Items {
+			if isJobRunning(&job) {
+				active[pvc.Name] = job.Name
+				break
+			}
+		}
+	}
+	return active, nil
+}
+
+// isJobRunning returns true if the job hasn't completed or failed
+func isJobRunning(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == v1.ConditionTrue {
+			return false
+		}
+	}
+	return job.DeletionTimestamp == nil
+}
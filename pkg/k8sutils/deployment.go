@@ -0,0 +1,341 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	storkContainerName = "stork"
+	driverFlag         = "driver"
+	driverShortFlag    = "d"
+	adminNamespaceFlag = "admin-namespace"
+	// migrationAdminNamespaceFlag is deprecated in favor of adminNamespaceFlag
+	migrationAdminNamespaceFlag = "migration-admin-namespace"
+	lockObjectNameFlag          = "lock-object-name"
+	lockObjectNamespaceFlag     = "lock-object-namespace"
+
+	// DefaultLockObjectName - default name of the lock object used by stork for
+	// leader election
+	DefaultLockObjectName = "stork"
+	// DefaultLockObjectNamespace - default namespace of the lock object used by
+	// stork for leader election
+	DefaultLockObjectNamespace = "kube-system"
+
+	// defaultDeploymentTimeout is how long to wait for the stork deployment if no
+	// timeout is given
+	defaultDeploymentTimeout = 5 * time.Minute
+)
+
+// getStorkContainer returns the stork container from the stork deployment. The
+// container is looked up by name, falling back to the first container for
+// deployments that renamed it.
+func getStorkContainer(deploy *appsv1.Deployment) (*v1.Container, error) {
+	containers := deploy.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("deployment [%s] %s has no containers", deploy.Namespace, deploy.Name)
+	}
+	for i := range containers {
+		if containers[i].Name == storkContainerName {
+			return &containers[i], nil
+		}
+	}
+	return &containers[0], nil
+}
+
+// getContainerFlagValues returns all the values passed to the given flag in the
+// container's command and args. Flags can be passed as --flag=value, --flag value,
+// or using the short name if one is given.
+func getContainerFlagValues(container *v1.Container, name, shortName string) []string {
+	names := []string{"--" + name}
+	if shortName != "" {
+		names = append(names, "-"+shortName)
+	}
+
+	args := append(append([]string{}, container.Command...), container.Args...)
+	values := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		for _, flagName := range names {
+			if strings.HasPrefix(args[i], flagName+"=") {
+				values = append(values, strings.TrimPrefix(args[i], flagName+"="))
+			} else if args[i] == flagName && i+1 < len(args) {
+				values = append(values, args[i+1])
+				i++
+			}
+		}
+	}
+	return values
+}
+
+// GetEnabledVolumeDrivers returns the names of the volume drivers that the stork
+// deployment in the given namespace has been configured with
+func GetEnabledVolumeDrivers(namespace string) ([]string, error) {
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return getDeploymentVolumeDrivers(deploy)
+}
+
+// getDeploymentVolumeDrivers returns the volume drivers passed to the stork
+// container in the given deployment
+func getDeploymentVolumeDrivers(deploy *appsv1.Deployment) ([]string, error) {
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]string, 0)
+	for _, value := range getContainerFlagValues(container, driverFlag, driverShortFlag) {
+		for _, driver := range strings.Split(value, ",") {
+			if driver = strings.TrimSpace(driver); driver != "" {
+				drivers = append(drivers, driver)
+			}
+		}
+	}
+	if len(drivers) == 0 {
+		return nil, fmt.Errorf("no volume driver configured for stork deployment [%s] %s", deploy.Namespace, deploy.Name)
+	}
+	return drivers, nil
+}
+
+// WaitForStorkRollout waits till the latest spec of the stork deployment in the
+// given namespace has been rolled out to all replicas. On timeout the error
+// includes the reason for any stork pods whose containers are stuck waiting,
+// like ImagePullBackOff after an image change.
+func WaitForStorkRollout(namespace string, timeout time.Duration) error {
+	var deploy *appsv1.Deployment
+	err := wait.PollImmediate(retryInterval, timeout, func() (bool, error) {
+		var err error
+		deploy, err = Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+		if err != nil {
+			return false, err
+		}
+		return isDeploymentRolledOut(deploy), nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	msg := fmt.Sprintf("timed out waiting for rollout of deployment [%s] %s", namespace, StorkDeploymentName)
+	if deploy == nil {
+		return fmt.Errorf("%s", msg)
+	}
+	msg = fmt.Sprintf("%s: generation %d, observed generation %d, replicas %d, updated %d, available %d", msg,
+		deploy.Generation, deploy.Status.ObservedGeneration, deploy.Status.Replicas,
+		deploy.Status.UpdatedReplicas, deploy.Status.AvailableReplicas)
+	if pods, err := Instance().Apps().GetDeploymentPods(deploy); err == nil {
+		stuck := getWaitingContainerReasons(pods)
+		if len(stuck) > 0 {
+			msg = fmt.Sprintf("%s, stuck pods: %s", msg, strings.Join(stuck, ", "))
+		}
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// WaitForStorkDeploymentReady waits till the expected number of replicas of the
+// stork deployment in the given namespace are ready and the controller has
+// observed the latest generation of the deployment
+func WaitForStorkDeploymentReady(namespace string, expectedReplicas int32, timeout time.Duration) error {
+	interval := retryInterval
+	if interval > timeout {
+		interval = timeout
+	}
+	return WaitForStorkDeploymentReadyWithContext(context.Background(), namespace, expectedReplicas,
+		WithTimeout(timeout), WithInterval(interval))
+}
+
+// WaitForStorkDeploymentReadyWithContext is WaitForStorkDeploymentReady with a
+// context to stop waiting. The poll options override the default timeout and
+// interval.
+func WaitForStorkDeploymentReadyWithContext(ctx context.Context, namespace string, expectedReplicas int32, pollOpts ...PollOption) error {
+	cfg := newPollConfig(defaultDeploymentTimeout, retryInterval, pollOpts)
+	var deploy *appsv1.Deployment
+	err := pollWithConfig(ctx, cfg, func(ctx context.Context) (bool, error) {
+		var err error
+		deploy, err = Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+		if err != nil {
+			return false, err
+		}
+		return deploy.Status.ReadyReplicas == expectedReplicas &&
+			deploy.Status.ObservedGeneration >= deploy.Generation, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	msg := fmt.Sprintf("timed out waiting for %d ready replicas of deployment [%s] %s", expectedReplicas, namespace, StorkDeploymentName)
+	if deploy == nil {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s: generation %d, observed generation %d, ready %d, updated %d, available %d", msg,
+		deploy.Generation, deploy.Status.ObservedGeneration, deploy.Status.ReadyReplicas,
+		deploy.Status.UpdatedReplicas, deploy.Status.AvailableReplicas)
+}
+
+// GetStorkDeploymentReplicas returns the number of replicas the stork deployment
+// in the given namespace has been scaled to
+func GetStorkDeploymentReplicas(namespace string) (int32, error) {
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if errors.IsNotFound(err) {
+		return 0, fmt.Errorf("deployment [%s] %s not found", namespace, StorkDeploymentName)
+	} else if err != nil {
+		return 0, err
+	}
+	if deploy.Spec.Replicas == nil {
+		return 1, nil
+	}
+	return *deploy.Spec.Replicas, nil
+}
+
+// ScaleStorkDeployment scales the stork deployment in the given namespace to the
+// given number of replicas. The scale subresource is used so that concurrent
+// changes to the rest of the deployment don't conflict with it.
+func ScaleStorkDeployment(namespace string, replicas int32) error {
+	if replicas < 0 {
+		return fmt.Errorf("invalid number of replicas %d for deployment [%s] %s", replicas, namespace, StorkDeploymentName)
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+	deployments := client.AppsV1().Deployments(namespace)
+	scale, err := deployments.GetScale(context.TODO(), StorkDeploymentName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("deployment [%s] %s not found", namespace, StorkDeploymentName)
+	} else if err != nil {
+		return err
+	}
+	if scale.Spec.Replicas == replicas {
+		return nil
+	}
+	scale.Spec.Replicas = replicas
+	if _, err := deployments.UpdateScale(context.TODO(), StorkDeploymentName, scale, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error scaling deployment [%s] %s to %d replicas: %v", namespace, StorkDeploymentName, replicas, err)
+	}
+	return nil
+}
+
+// ScaleStorkDeploymentAndWait scales the stork deployment in the given namespace
+// to the given number of replicas and waits till all of them have been rolled out,
+// or till all the pods are gone when scaling to zero
+func ScaleStorkDeploymentAndWait(namespace string, replicas int32, timeout time.Duration) error {
+	if err := ScaleStorkDeployment(namespace, replicas); err != nil {
+		return err
+	}
+	var deploy *appsv1.Deployment
+	err := wait.PollImmediate(retryInterval, timeout, func() (bool, error) {
+		var err error
+		deploy, err = Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+		if err != nil {
+			return false, err
+		}
+		return deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == replicas && isDeploymentRolledOut(deploy), nil
+	})
+	if err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	msg := fmt.Sprintf("timed out waiting for deployment [%s] %s to be scaled to %d replicas", namespace, StorkDeploymentName, replicas)
+	if deploy == nil {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s: replicas %d, updated %d, available %d", msg,
+		deploy.Status.Replicas, deploy.Status.UpdatedReplicas, deploy.Status.AvailableReplicas)
+}
+
+// isDeploymentRolledOut returns true if the controller has observed the latest
+// generation of the deployment and all replicas are updated and available
+func isDeploymentRolledOut(deploy *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas == replicas &&
+		deploy.Status.Replicas == replicas &&
+		deploy.Status.AvailableReplicas == replicas
+}
+
+// getWaitingContainerReasons returns <pod>: <reason> for every container in the
+// given pods that is waiting to start
+func getWaitingContainerReasons(pods []v1.Pod) []string {
+	reasons := make([]string, 0)
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", pod.Name, status.State.Waiting.Reason))
+			}
+		}
+	}
+	return reasons
+}
+
+// GetStorkAdminNamespace returns the admin namespace that stork has been
+// configured with through the --admin-namespace flag. Like stork, the deprecated
+// --migration-admin-namespace flag is only used if --admin-namespace is set to
+// an empty string. DefaultAdminNamespace is returned if neither is set.
+func GetStorkAdminNamespace() (string, error) {
+	namespace, err := GetStorkPodNamespace()
+	if err != nil {
+		return "", err
+	}
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if err != nil {
+		return "", err
+	}
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return "", err
+	}
+	return getContainerAdminNamespace(container), nil
+}
+
+// getContainerAdminNamespace returns the admin namespace passed to the stork
+// container, using the same precedence and defaults as stork
+func getContainerAdminNamespace(container *v1.Container) string {
+	values := getContainerFlagValues(container, adminNamespaceFlag, "")
+	if len(values) == 0 {
+		return DefaultAdminNamespace
+	}
+	if values[len(values)-1] != "" {
+		return values[len(values)-1]
+	}
+	if values := getContainerFlagValues(container, migrationAdminNamespaceFlag, ""); len(values) > 0 && values[len(values)-1] != "" {
+		return values[len(values)-1]
+	}
+	return DefaultAdminNamespace
+}
+
+// GetStorkLeaseRef returns the namespace and name of the lock object used for
+// leader election by the stork deployment in the given namespace. These are read
+// from the --lock-object-namespace and --lock-object-name flags, defaulting to
+// DefaultLockObjectNamespace and DefaultLockObjectName like stork does.
+func GetStorkLeaseRef(namespace string) (string, string, error) {
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if err != nil {
+		return "", "", err
+	}
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return "", "", err
+	}
+
+	leaseNamespace := DefaultLockObjectNamespace
+	if values := getContainerFlagValues(container, lockObjectNamespaceFlag, ""); len(values) > 0 && values[len(values)-1] != "" {
+		leaseNamespace = values[len(values)-1]
+	}
+	leaseName := DefaultLockObjectName
+	if values := getContainerFlagValues(container, lockObjectNameFlag, ""); len(values) > 0 && values[len(values)-1] != "" {
+		leaseName = values[len(values)-1]
+	}
+	return leaseNamespace, leaseName, nil
+}
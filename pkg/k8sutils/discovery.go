@@ -0,0 +1,84 @@
+package k8sutils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	// preferredVersionCacheTTL is how long the preferred version of a resource is
+	// cached before discovery is queried again
+	preferredVersionCacheTTL = 1 * time.Minute
+)
+
+type preferredVersionCacheEntry struct {
+	version string
+	expiry  time.Time
+}
+
+var (
+	preferredVersionCache     = make(map[string]preferredVersionCacheEntry)
+	preferredVersionCacheLock sync.Mutex
+)
+
+// PreferredStorkResourceVersion returns the version of the given resource that is
+// preferred by the server. If the resource isn't served in the preferred version
+// of the group, the first other version of the group that serves it is returned.
+// Results are cached for a short time to avoid querying discovery on every call.
+func PreferredStorkResourceVersion(client discovery.DiscoveryInterface, group, resource string) (string, error) {
+	key := group + "/" + resource
+	preferredVersionCacheLock.Lock()
+	entry, ok := preferredVersionCache[key]
+	preferredVersionCacheLock.Unlock()
+	if ok && time.Now().Before(entry.expiry) {
+		return entry.version, nil
+	}
+
+	version, err := getPreferredResourceVersion(client, group, resource)
+	if err != nil {
+		return "", err
+	}
+	preferredVersionCacheLock.Lock()
+	preferredVersionCache[key] = preferredVersionCacheEntry{
+		version: version,
+		expiry:  time.Now().Add(preferredVersionCacheTTL),
+	}
+	preferredVersionCacheLock.Unlock()
+	return version, nil
+}
+
+// getPreferredResourceVersion queries discovery for the preferred version of the
+// given resource
+func getPreferredResourceVersion(client discovery.DiscoveryInterface, group, resource string) (string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", err
+	}
+	for _, apiGroup := range groups.Groups {
+		if apiGroup.Name != group {
+			continue
+		}
+		versions := []string{apiGroup.PreferredVersion.Version}
+		for _, version := range apiGroup.Versions {
+			if version.Version != apiGroup.PreferredVersion.Version {
+				versions = append(versions, version.Version)
+			}
+		}
+		for _, version := range versions {
+			resources, err := client.ServerResourcesForGroupVersion(group + "/" + version)
+			if err != nil {
+				return "", err
+			}
+			for _, r := range resources.APIResources {
+				if r.Name == resource {
+					return version, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("resource %s is not served by group %s", resource, group)
+	}
+	return "", fmt.Errorf("group %s is not served by the cluster", group)
+}
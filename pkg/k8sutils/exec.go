@@ -0,0 +1,81 @@
+package k8sutils
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ExecPreSnapshotCommand runs the command in the container that mounts the
+// given PVC, in the first running pod using it. This can be used to quiesce an
+// application, for example by locking tables in a database, before its volumes
+// are snapshotted. An error is returned if the command fails or doesn't finish
+// within the timeout. The command isn't killed on timeout, so it should not
+// block indefinitely.
+func ExecPreSnapshotCommand(namespace string, pvc v1.PersistentVolumeClaim, command []string, timeout time.Duration) error {
+	if len(command) == 0 {
+		return fmt.Errorf("command is required")
+	}
+	pods, err := Instance().Core().GetPodsUsingPVC(pvc.Name, namespace)
+	if err != nil {
+		return err
+	}
+	var pod *v1.Pod
+	for i := range pods {
+		if pods[i].Status.Phase == v1.PodRunning {
+			pod = &pods[i]
+			break
+		}
+	}
+	if pod == nil {
+		return fmt.Errorf("no running pod found using PVC [%s] %s", namespace, pvc.Name)
+	}
+	container := getPVCContainer(pod, pvc.Name)
+	if container == "" {
+		return fmt.Errorf("no container in pod [%s] %s mounts PVC %s", namespace, pod.Name, pvc.Name)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		output, err := Instance().Core().RunCommandInPod(command, pod.Name, container, namespace)
+		if err != nil {
+			err = fmt.Errorf("error running command %v in pod [%s] %s: %v: %s", command, namespace, pod.Name, err, output)
+		}
+		errChan <- err
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v running command %v in pod [%s] %s", timeout, command, namespace, pod.Name)
+	}
+}
+
+// getPVCContainer returns the name of the first container in the pod that
+// mounts the given PVC
+func getPVCContainer(pod *v1.Pod, pvcName string) string {
+	volumeName := ""
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvcName {
+			volumeName = volume.Name
+			break
+		}
+	}
+	if volumeName == "" {
+		return ""
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, mount := range container.VolumeMounts {
+			if mount.Name == volumeName {
+				return container.Name
+			}
+		}
+		for _, device := range container.VolumeDevices {
+			if device.Name == volumeName {
+				return container.Name
+			}
+		}
+	}
+	return ""
+}
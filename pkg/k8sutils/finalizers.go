@@ -0,0 +1,197 @@
+package k8sutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// StorkCleanupFinalizer - finalizer set by the stork controllers on their CRs
+	// so they can clean up before the CRs are deleted
+	StorkCleanupFinalizer = StorkAnnotationPrefix + "finalizer-cleanup"
+)
+
+var (
+	// storkFinalizerResources are the stork CRs that the controllers set
+	// StorkCleanupFinalizer on, keyed by kind
+	storkFinalizerResources = map[string]schema.GroupVersionResource{
+		"ApplicationBackup":     {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "applicationbackups"},
+		"ApplicationClone":      {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "applicationclones"},
+		"ApplicationRestore":    {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "applicationrestores"},
+		"ClusterPair":           {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "clusterpairs"},
+		"GroupVolumeSnapshot":   {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "groupvolumesnapshots"},
+		"Migration":             {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "migrations"},
+		"MigrationSchedule":     {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "migrationschedules"},
+		"VolumeSnapshotRestore": {Group: "stork.libopenstorage.org", Version: "v1alpha1", Resource: "volumesnapshotrestores"},
+	}
+)
+
+// CleanupStaleStorkFinalizers removes the stork finalizers from the objects in
+// the given namespace that have been stuck deleting for longer than olderThan,
+// which can happen if stork was restarted in the middle of cleaning up. Stork
+// CRs are cleaned up directly, while PVCs are only cleaned up if the stork CR
+// that owns them no longer exists. olderThan is required so that objects that
+// stork is still cleaning up aren't touched. The returned names are of the form
+// <resource>/<name>.
+func CleanupStaleStorkFinalizers(namespace string, olderThan time.Duration) ([]string, error) {
+	if olderThan <= 0 {
+		return nil, fmt.Errorf("olderThan must be greater than zero")
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	cleaned := make([]string, 0)
+
+	for _, gvr := range storkFinalizerResources {
+		list, err := client.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return cleaned, fmt.Errorf("error listing %s in namespace %s: %v", gvr.Resource, namespace, err)
+		}
+		for _, obj := range list.Items {
+			if !isStaleDeletion(obj.GetDeletionTimestamp(), cutoff) {
+				continue
+			}
+			finalizers, removed := removeStorkFinalizers(obj.GetFinalizers())
+			if !removed {
+				continue
+			}
+			patch, err := json.Marshal(map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"finalizers":      finalizers,
+					"resourceVersion": obj.GetResourceVersion(),
+				},
+			})
+			if err != nil {
+				return cleaned, err
+			}
+			_, err = client.Resource(gvr).Namespace(namespace).Patch(context.TODO(), obj.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return cleaned, fmt.Errorf("error removing finalizers from %s [%s] %s: %v", gvr.Resource, namespace, obj.GetName(), err)
+			}
+			cleaned = append(cleaned, gvr.Resource+"/"+obj.GetName())
+		}
+	}
+
+	pvcs, err := Instance().Core().GetPersistentVolumeClaims(namespace, nil)
+	if err != nil {
+		return cleaned, err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !isStaleDeletion(pvc.DeletionTimestamp, cutoff) {
+			continue
+		}
+		if _, removed := removeStorkFinalizers(pvc.Finalizers); !removed {
+			continue
+		}
+		ownerExists, err := hasStorkOwner(client, pvc)
+		if err != nil {
+			return cleaned, err
+		} else if ownerExists {
+			continue
+		}
+		err = PatchWithRetry(pvc, func(obj metav1.Object) {
+			finalizers, _ := removeStorkFinalizers(obj.GetFinalizers())
+			obj.SetFinalizers(finalizers)
+		}, defaultPatchRetries)
+		if err != nil && !errors.IsNotFound(err) {
+			return cleaned, err
+		}
+		cleaned = append(cleaned, "persistentvolumeclaims/"+pvc.Name)
+	}
+	return cleaned, nil
+}
+
+// RemoveFinalizersFromCRs removes all the finalizers from the custom resources
+// of the given resource in the given namespace, or in all namespaces if the
+// namespace is empty. It is used during uninstall, when the controllers that
+// would remove the finalizers are already gone and the custom resources would
+// otherwise block the deletion of their CRD. Resources without finalizers are
+// skipped.
+func RemoveFinalizersFromCRs(gvr schema.GroupVersionResource, namespace string) error {
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	cleaned, err := removeFinalizersFromCRs(client, gvr, namespace)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Removed finalizers from %d %s", cleaned, gvr.Resource)
+	return nil
+}
+
+// removeFinalizersFromCRs removes the finalizers from the custom resources of
+// the given resource and returns the number of resources that were cleaned
+func removeFinalizersFromCRs(client dynamic.Interface, gvr schema.GroupVersionResource, namespace string) (int, error) {
+	list, err := client.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if errors.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error listing %s in namespace %q: %v", gvr.Resource, namespace, err)
+	}
+	patch := []byte(`{"metadata":{"finalizers":null}}`)
+	cleaned := 0
+	for _, obj := range list.Items {
+		if len(obj.GetFinalizers()) == 0 {
+			continue
+		}
+		_, err := client.Resource(gvr).Namespace(obj.GetNamespace()).Patch(context.TODO(), obj.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return cleaned, fmt.Errorf("error removing finalizers from %s [%s] %s: %v", gvr.Resource, obj.GetNamespace(), obj.GetName(), err)
+		}
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// isStaleDeletion returns true if the object was marked for deletion before the cutoff
+func isStaleDeletion(deletionTimestamp *metav1.Time, cutoff time.Time) bool {
+	return deletionTimestamp != nil && deletionTimestamp.Time.Before(cutoff)
+}
+
+// removeStorkFinalizers returns the finalizers without the ones added by stork,
+// and whether any were removed
+func removeStorkFinalizers(finalizers []string) ([]string, bool) {
+	remaining := make([]string, 0, len(finalizers))
+	for _, finalizer := range finalizers {
+		if !strings.HasPrefix(finalizer, StorkAnnotationPrefix) {
+			remaining = append(remaining, finalizer)
+		}
+	}
+	return remaining, len(remaining) != len(finalizers)
+}
+
+// hasStorkOwner returns true if any of the stork CRs that own the PVC still exist
+func hasStorkOwner(client dynamic.Interface, pvc *v1.PersistentVolumeClaim) (bool, error) {
+	for _, owner := range pvc.OwnerReferences {
+		gvr, ok := storkFinalizerResources[owner.Kind]
+		if !ok || !strings.HasPrefix(owner.APIVersion, gvr.Group+"/") {
+			continue
+		}
+		_, err := client.Resource(gvr).Namespace(pvc.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+		if err == nil {
+			return true, nil
+		} else if !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
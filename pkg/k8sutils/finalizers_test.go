@@ -0,0 +1,51 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestRemoveFinalizersFromCRs(t *testing.T) {
+	gvr := storkFinalizerResources["Migration"]
+	newMigration := func(namespace, name string, finalizers ...string) runtime.Object {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(gvr.Group + "/" + gvr.Version)
+		obj.SetKind("Migration")
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetFinalizers(finalizers)
+		return obj
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "MigrationList"},
+		newMigration("ns1", "migration1", StorkCleanupFinalizer),
+		newMigration("ns1", "migration2"),
+		newMigration("ns2", "migration3", StorkCleanupFinalizer, "example.com/finalizer"),
+	)
+
+	cleaned, err := removeFinalizersFromCRs(client, gvr, "ns1")
+	require.NoError(t, err)
+	require.Equal(t, 1, cleaned, "Expected only the CR with finalizers to be cleaned")
+
+	cleaned, err = removeFinalizersFromCRs(client, gvr, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, cleaned, "Expected CRs in all namespaces to be cleaned")
+
+	obj, err := client.Resource(gvr).Namespace("ns2").Get(context.TODO(), "migration3", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, obj.GetFinalizers())
+
+	cleaned, err = removeFinalizersFromCRs(client, gvr, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, cleaned, "Expected cleanup to be idempotent")
+}
@@ -0,0 +1,909 @@
+package k8sutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StorkSnapshotStorageClass - storage class used by stork to provision PVCs
+	// from snapshots
+	StorkSnapshotStorageClass = "stork-snapshot-sc"
+	hostnameTopologyKey       = "kubernetes.io/hostname"
+	zoneTopologyKey           = "topology.kubernetes.io/zone"
+	// zoneTopologyKeyDeprecated is the beta zone label used by older clusters
+	zoneTopologyKeyDeprecated = "failure-domain.beta.kubernetes.io/zone"
+	// groupSnapshotStageFinal is the stage of a GroupVolumeSnapshot that is done
+	groupSnapshotStageFinal = "Final"
+)
+
+var (
+	groupVolumeSnapshotGVR = schema.GroupVersionResource{
+		Group:    "stork.libopenstorage.org",
+		Version:  "v1alpha1",
+		Resource: "groupvolumesnapshots",
+	}
+	// groupSnapshotMemberGVR is the resource of the VolumeSnapshots created for
+	// each PVC in a group snapshot
+	groupSnapshotMemberGVR = crdv1.SchemeGroupVersion.WithResource(crdv1.VolumeSnapshotResourcePlural)
+	ruleGVR                = schema.GroupVersionResource{
+		Group:    "stork.libopenstorage.org",
+		Version:  "v1alpha1",
+		Resource: "rules",
+	}
+)
+
+// PVCBindEvent is sent by WatchGroupSnapshotPVCs when a PVC gets bound
+type PVCBindEvent struct {
+	// PVC that was bound
+	PVC *v1.PersistentVolumeClaim
+	// Phase of the PVC
+	Phase v1.PersistentVolumeClaimPhase
+}
+
+// GroupSnapshotFingerprint returns a deterministic hash of the given PVCs. The hash
+// is computed over the sorted set of "namespace/name/volumeName" tuples so the
+// order in which the PVCs were listed doesn't affect the result.
+func GroupSnapshotFingerprint(pvcs []v1.PersistentVolumeClaim) string {
+	members := make([]string, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		members = append(members, fmt.Sprintf("%s/%s/%s", pvc.Namespace, pvc.Name, pvc.Spec.VolumeName))
+	}
+	sort.Strings(members)
+
+	sum := sha256.Sum256([]byte(strings.Join(members, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListGroupSnapshotMembers returns the VolumeSnapshots that were created for the
+// given group snapshot, sorted by name. The group snapshot controller creates an
+// external-storage VolumeSnapshot for each PVC in the group with the
+// GroupVolumeSnapshot as its owner, so the members are matched on the UID of the
+// owner.
+func ListGroupSnapshotMembers(namespace, groupName string) ([]unstructured.Unstructured, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	groupSnapshot, err := client.Resource(groupVolumeSnapshotGVR).Namespace(namespace).Get(context.TODO(), groupName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting group snapshot [%s] %s: %v", namespace, groupName, err)
+	}
+	snapshots, err := client.Resource(groupSnapshotMemberGVR).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VolumeSnapshots in namespace %s: %v", namespace, err)
+	}
+
+	members := make([]unstructured.Unstructured, 0)
+	for _, snapshot := range snapshots.Items {
+		for _, owner := range snapshot.GetOwnerReferences() {
+			if owner.UID == groupSnapshot.GetUID() {
+				members = append(members, snapshot)
+				break
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].GetName() < members[j].GetName()
+	})
+	return members, nil
+}
+
+// getGroupSnapshotMemberPVC returns the name of the PVC a member of a group
+// snapshot was taken from
+func getGroupSnapshotMemberPVC(member unstructured.Unstructured) string {
+	pvcName, _, _ := unstructured.NestedString(member.Object, "spec", "persistentVolumeClaimName")
+	return pvcName
+}
+
+// isGroupSnapshotMemberReady returns true if a member of a group snapshot has a
+// Ready condition that is true
+func isGroupSnapshotMemberReady(member unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(member.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if fields["type"] == string(crdv1.VolumeSnapshotConditionReady) && fields["status"] == string(v1.ConditionTrue) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyGroupSnapshotCompleteness returns the names of the expected PVCs that
+// don't have a member VolumeSnapshot in the given group snapshot
+func VerifyGroupSnapshotCompleteness(namespace, groupName string, expectedPVCs []v1.PersistentVolumeClaim) ([]string, error) {
+	members, err := ListGroupSnapshotMembers(namespace, groupName)
+	if err != nil {
+		return nil, err
+	}
+	snapshotted := make(map[string]bool)
+	for _, member := range members {
+		if pvcName := getGroupSnapshotMemberPVC(member); pvcName != "" {
+			snapshotted[pvcName] = true
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, pvc := range expectedPVCs {
+		if !snapshotted[pvc.Name] {
+			missing = append(missing, pvc.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// BuildRestorePVCs returns the specs for PVCs that restore each member of the
+// given group snapshot. The PVCs are provisioned by stork from the member
+// VolumeSnapshot set in their snapshot annotation, using the given storage class
+// or StorkSnapshotStorageClass if it is empty. They are named after the PVC the
+// snapshot was taken from, with a -restore suffix, and copy its requested size,
+// access modes and volume mode, so that PVC has to still exist.
+func BuildRestorePVCs(namespace, groupName, storageClass string) ([]v1.PersistentVolumeClaim, error) {
+	members, err := ListGroupSnapshotMembers(namespace, groupName)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("group snapshot [%s] %s has no member snapshots", namespace, groupName)
+	}
+	if storageClass == "" {
+		storageClass = StorkSnapshotStorageClass
+	}
+
+	pvcs := make([]v1.PersistentVolumeClaim, 0, len(members))
+	for _, member := range members {
+		sourcePVC := getGroupSnapshotMemberPVC(member)
+		if sourcePVC == "" {
+			return nil, fmt.Errorf("VolumeSnapshot [%s] %s does not have a source PVC", namespace, member.GetName())
+		}
+		source, err := Instance().Core().GetPersistentVolumeClaim(sourcePVC, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("error getting source PVC %s of VolumeSnapshot [%s] %s: %v", sourcePVC, namespace, member.GetName(), err)
+		}
+		size, ok := source.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			return nil, fmt.Errorf("source PVC [%s] %s of VolumeSnapshot %s does not request any storage", namespace, sourcePVC, member.GetName())
+		}
+
+		className := storageClass
+		pvcs = append(pvcs, v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sourcePVC + "-restore",
+				Namespace: namespace,
+				Annotations: map[string]string{
+					snapshotclient.SnapshotPVCAnnotation: member.GetName(),
+				},
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &className,
+				AccessModes:      source.Spec.AccessModes,
+				VolumeMode:       source.Spec.VolumeMode,
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: size},
+				},
+			},
+		})
+	}
+	return pvcs, nil
+}
+
+// CancelGroupSnapshot asks the group snapshot controller to abort the given group
+// snapshot if it is still in progress, by setting GroupSnapshotCancelAnnotation
+// on it. The controller terminates any background commands of the pre-exec rule,
+// deletes the member VolumeSnapshots that aren't ready yet and, if the pre-exec
+// rule has been run, runs the post-exec rule to un-quiesce the apps. It then
+// fails the group snapshot in its final stage. This is a no-op if the group
+// snapshot has already reached its final stage.
+func CancelGroupSnapshot(namespace, groupName string) error {
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	groupSnapshot, err := client.Resource(groupVolumeSnapshotGVR).Namespace(namespace).Get(context.TODO(), groupName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting group snapshot [%s] %s: %v", namespace, groupName, err)
+	}
+	stage, _, _ := unstructured.NestedString(groupSnapshot.Object, "status", "stage")
+	if stage == groupSnapshotStageFinal {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{GroupSnapshotCancelAnnotation: "true"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(groupVolumeSnapshotGVR).Namespace(namespace).Patch(context.TODO(), groupName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error cancelling group snapshot [%s] %s: %v", namespace, groupName, err)
+	}
+	return nil
+}
+
+// RelabelGroupSnapshotMembers sets the given labels on all the member
+// VolumeSnapshots of the given group snapshot. The group snapshot controller
+// copies the labels of the group snapshot onto the members when it creates
+// them, so this keeps the members in sync when those labels change afterwards.
+// Other labels on the members are left alone.
+func RelabelGroupSnapshotMembers(namespace, groupName string, newLabels map[string]string) error {
+	if len(newLabels) == 0 {
+		return nil
+	}
+	members, err := ListGroupSnapshotMembers(namespace, groupName)
+	if err != nil {
+		return err
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": newLabels},
+	})
+	if err != nil {
+		return err
+	}
+	var patchErr error
+	for _, member := range members {
+		_, err = client.Resource(groupSnapshotMemberGVR).Namespace(namespace).Patch(context.TODO(), member.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			patchErr = multierror.Append(patchErr, fmt.Errorf("error labeling VolumeSnapshot [%s] %s: %v", namespace, member.GetName(), err))
+		}
+	}
+	return patchErr
+}
+
+// FilterSnapshottablePVCs splits the PVCs into the ones that can be
+// snapshotted and the ones that can't. PVCs that aren't bound yet, or whose
+// volumes are hostPath, local or NFS volumes, are skipped since no snapshotter
+// can handle them and they would fail the whole group snapshot.
+func FilterSnapshottablePVCs(pvcs []v1.PersistentVolumeClaim) (snapshottable, skipped []v1.PersistentVolumeClaim, err error) {
+	snapshottable = make([]v1.PersistentVolumeClaim, 0)
+	skipped = make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcs {
+		if pvc.Spec.VolumeName == "" {
+			skipped = append(skipped, pvc)
+			continue
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting PV %s for PVC [%s] %s: %v", pvc.Spec.VolumeName, pvc.Namespace, pvc.Name, err)
+		}
+		if isSnapshottablePV(pv) {
+			snapshottable = append(snapshottable, pvc)
+		} else {
+			skipped = append(skipped, pvc)
+		}
+	}
+	return snapshottable, skipped, nil
+}
+
+// isSnapshottablePV returns false for volume sources that can't be snapshotted
+func isSnapshottablePV(pv *v1.PersistentVolume) bool {
+	source := pv.Spec.PersistentVolumeSource
+	return source.HostPath == nil && source.Local == nil && source.NFS == nil
+}
+
+// CreateGroupVolumeSnapshot creates a GroupVolumeSnapshot with the given name
+// for the PVCs in the namespace that match the labels, running the given pre
+// and post snapshot rules if they are set. The selector is validated with
+// GetPVCsForGroupSnapshot first, and the rules have to exist in the namespace.
+func CreateGroupVolumeSnapshot(namespace, name string, matchLabels map[string]string, preRule, postRule string) error {
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return err
+	}
+	if _, err := GetPVCsForGroupSnapshot(namespace, matchLabels); err != nil {
+		matched := 0
+		if pvcList, listErr := Instance().Core().GetPersistentVolumeClaims(namespace, matchLabels); listErr == nil {
+			matched = len(pvcList.Items)
+		}
+		return fmt.Errorf("invalid PVC selector for group snapshot [%s] %s, matched %d PVCs: %v", namespace, name, matched, err)
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	for _, ruleName := range []string{preRule, postRule} {
+		if ruleName == "" {
+			continue
+		}
+		if _, err := client.Resource(ruleGVR).Namespace(namespace).Get(context.TODO(), ruleName, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("error getting rule [%s] %s for group snapshot %s: %v", namespace, ruleName, name, err)
+		}
+	}
+
+	selectorLabels := make(map[string]interface{})
+	for key, value := range matchLabels {
+		selectorLabels[key] = value
+	}
+	groupSnapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": groupVolumeSnapshotGVR.GroupVersion().String(),
+			"kind":       "GroupVolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"preExecRule":  preRule,
+				"postExecRule": postRule,
+				"pvcSelector": map[string]interface{}{
+					"matchLabels": selectorLabels,
+				},
+			},
+		},
+	}
+	if _, err := client.Resource(groupVolumeSnapshotGVR).Namespace(namespace).Create(context.TODO(), groupSnapshot, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating group snapshot [%s] %s: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// WaitForGroupSnapshotReady waits till expectedCount member VolumeSnapshots have
+// been created for the given group snapshot and all of them have a Ready
+// condition
+func WaitForGroupSnapshotReady(namespace, groupName string, expectedCount int, timeout time.Duration) error {
+	var notReady []string
+	var found int
+	err := wait.PollImmediate(retryInterval, timeout, func() (bool, error) {
+		members, err := ListGroupSnapshotMembers(namespace, groupName)
+		if err != nil {
+			return false, err
+		}
+		found = len(members)
+		notReady = make([]string, 0)
+		for _, member := range members {
+			if !isGroupSnapshotMemberReady(member) {
+				notReady = append(notReady, member.GetName())
+			}
+		}
+		return found >= expectedCount && len(notReady) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		if found < expectedCount {
+			return fmt.Errorf("timed out waiting for group snapshot [%s] %s: found %d of %d members, not ready: %v",
+				namespace, groupName, found, expectedCount, notReady)
+		}
+		return fmt.Errorf("timed out waiting for group snapshot [%s] %s: members not ready: %v",
+			namespace, groupName, notReady)
+	}
+	return err
+}
+
+// OrderPVCsForSnapshot returns the PVCs sorted by the integer value of the given
+// annotation, lowest first, so that volumes that other volumes depend on can be
+// snapshotted first. PVCs without the annotation go last. The order of PVCs with
+// the same value is preserved.
+func OrderPVCsForSnapshot(pvcs []v1.PersistentVolumeClaim, orderAnnotation string) ([]v1.PersistentVolumeClaim, error) {
+	type orderedPVC struct {
+		pvc     v1.PersistentVolumeClaim
+		order   int
+		ordered bool
+	}
+	entries := make([]orderedPVC, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		entry := orderedPVC{pvc: pvc}
+		if value, ok := pvc.Annotations[orderAnnotation]; ok {
+			order, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for annotation %s on PVC [%s] %s: %v",
+					value, orderAnnotation, pvc.Namespace, pvc.Name, err)
+			}
+			entry.order = order
+			entry.ordered = true
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].ordered != entries[j].ordered {
+			return entries[i].ordered
+		}
+		return entries[i].order < entries[j].order
+	})
+	ordered := make([]v1.PersistentVolumeClaim, 0, len(entries))
+	for _, entry := range entries {
+		ordered = append(ordered, entry.pvc)
+	}
+	return ordered, nil
+}
+
+// DetectPVCNameCollisions returns the names of the PVCs that appear in more than
+// one namespace along with the sorted list of namespaces they appear in. Members
+// of a group that spans namespaces should be keyed by namespace and name if this
+// returns any collisions.
+func DetectPVCNameCollisions(pvcs []v1.PersistentVolumeClaim) map[string][]string {
+	namespaces := make(map[string]map[string]bool)
+	for _, pvc := range pvcs {
+		if _, ok := namespaces[pvc.Name]; !ok {
+			namespaces[pvc.Name] = make(map[string]bool)
+		}
+		namespaces[pvc.Name][pvc.Namespace] = true
+	}
+
+	collisions := make(map[string][]string)
+	for name, set := range namespaces {
+		if len(set) < 2 {
+			continue
+		}
+		list := make([]string, 0, len(set))
+		for namespace := range set {
+			list = append(list, namespace)
+		}
+		sort.Strings(list)
+		collisions[name] = list
+	}
+	return collisions
+}
+
+// DiffPVCGroups compares two sets of PVCs by namespaced name and returns the PVCs
+// that were added to and removed from current compared to old
+func DiffPVCGroups(old, current []v1.PersistentVolumeClaim) (added, removed []v1.PersistentVolumeClaim) {
+	key := func(pvc v1.PersistentVolumeClaim) string {
+		return pvc.Namespace + "/" + pvc.Name
+	}
+	oldKeys := make(map[string]bool)
+	for _, pvc := range old {
+		oldKeys[key(pvc)] = true
+	}
+	currentKeys := make(map[string]bool)
+	for _, pvc := range current {
+		currentKeys[key(pvc)] = true
+	}
+
+	added = make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range current {
+		if !oldKeys[key(pvc)] {
+			added = append(added, pvc)
+		}
+	}
+	removed = make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range old {
+		if !currentKeys[key(pvc)] {
+			removed = append(removed, pvc)
+		}
+	}
+	return added, removed
+}
+
+// GetNodesForPVCGroup returns the names of the PVs bound to the given PVCs keyed
+// by the nodes they are local to. The nodes are taken from the hostname terms in
+// the nodeAffinity of the PV, or from the VolumeAttachments created by the given
+// CSI driver for PVs without node affinity.
+func GetNodesForPVCGroup(pvcs []v1.PersistentVolumeClaim, driverName string) (map[string][]string, error) {
+	nodes := make(map[string][]string)
+	var attachments *storagev1.VolumeAttachmentList
+	for _, pvc := range pvcs {
+		if pvc.Spec.VolumeName == "" {
+			return nil, fmt.Errorf("PVC [%s] %s is not bound to a PV", pvc.Namespace, pvc.Name)
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, err
+		}
+
+		pvNodes := getPVAffinityNodes(pv)
+		if len(pvNodes) == 0 {
+			if attachments == nil {
+				if attachments, err = Instance().Storage().ListVolumeAttachments(); err != nil {
+					return nil, err
+				}
+			}
+			pvNodes = getPVAttachedNodes(pv.Name, driverName, attachments)
+		}
+		for _, node := range pvNodes {
+			nodes[node] = append(nodes[node], pv.Name)
+		}
+	}
+	return nodes, nil
+}
+
+// getPVAffinityNodes returns the nodes the PV is restricted to by hostname terms
+// in its required nodeAffinity
+func getPVAffinityNodes(pv *v1.PersistentVolume) []string {
+	nodes := make([]string, 0)
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nodes
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == hostnameTopologyKey && expr.Operator == v1.NodeSelectorOpIn {
+				nodes = append(nodes, expr.Values...)
+			}
+		}
+	}
+	return nodes
+}
+
+// getPVAttachedNodes returns the nodes the PV has been attached to by the given
+// CSI driver
+func getPVAttachedNodes(pvName, driverName string, attachments *storagev1.VolumeAttachmentList) []string {
+	nodes := make([]string, 0)
+	for _, attachment := range attachments.Items {
+		if attachment.Spec.Source.PersistentVolumeName == nil || *attachment.Spec.Source.PersistentVolumeName != pvName {
+			continue
+		}
+		if driverName != "" && attachment.Spec.Attacher != driverName {
+			continue
+		}
+		if attachment.Status.Attached {
+			nodes = append(nodes, attachment.Spec.NodeName)
+		}
+	}
+	return nodes
+}
+
+// GetPVCGroupTopology returns the names of the PVs bound to the given PVCs keyed
+// by the zone from their nodeAffinity. PVs that aren't restricted to a zone are
+// returned under the empty key. More than one key means the group spans
+// multiple failure domains.
+func GetPVCGroupTopology(pvcs []v1.PersistentVolumeClaim) (map[string][]string, error) {
+	zones := make(map[string][]string)
+	for _, pvc := range pvcs {
+		if pvc.Spec.VolumeName == "" {
+			return nil, fmt.Errorf("PVC [%s] %s is not bound to a PV", pvc.Namespace, pvc.Name)
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, err
+		}
+		pvZones := getPVAffinityZones(pv)
+		if len(pvZones) == 0 {
+			zones[""] = append(zones[""], pv.Name)
+			continue
+		}
+		for _, zone := range pvZones {
+			zones[zone] = append(zones[zone], pv.Name)
+		}
+	}
+	return zones, nil
+}
+
+// getPVAffinityZones returns the zones the PV is restricted to by its required
+// nodeAffinity
+func getPVAffinityZones(pv *v1.PersistentVolume) []string {
+	zones := make([]string, 0)
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return zones
+	}
+	seen := make(map[string]bool)
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key != zoneTopologyKey && expr.Key != zoneTopologyKeyDeprecated {
+				continue
+			}
+			if expr.Operator != v1.NodeSelectorOpIn {
+				continue
+			}
+			for _, zone := range expr.Values {
+				if !seen[zone] {
+					seen[zone] = true
+					zones = append(zones, zone)
+				}
+			}
+		}
+	}
+	return zones
+}
+
+// WatchGroupSnapshotPVCs watches the PVCs in the namespace that match the given
+// labels and sends an event on the returned channel every time one of them
+// transitions to Bound. PVCs that are already bound when the watch starts are
+// sent too. The labels are checked and normalized like for
+// GetPVCsForGroupSnapshot. If the watch fails, for example because the resource
+// version it was resumed from has expired, the PVCs are listed again and the
+// watch is resumed from the list. The channel is closed when the context is
+// canceled or the watch can't be re-established.
+func WatchGroupSnapshotPVCs(ctx context.Context, namespace string, matchLabels map[string]string) (<-chan PVCBindEvent, error) {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, false); err != nil {
+		return nil, err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, err
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	return watchPVCBindEvents(ctx, client, namespace, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(matchLabels).String(),
+	})
+}
+
+// watchPVCBindEvents starts the watch for WatchGroupSnapshotPVCs
+func watchPVCBindEvents(ctx context.Context, client kubernetes.Interface, namespace string, listOptions metav1.ListOptions) (<-chan PVCBindEvent, error) {
+	watcher, err := client.CoreV1().PersistentVolumeClaims(namespace).Watch(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error watching PVCs in namespace %s with labels %s: %v", namespace, listOptions.LabelSelector, err)
+	}
+
+	events := make(chan PVCBindEvent)
+	go streamPVCBindEvents(ctx, client, namespace, listOptions, watcher, events)
+	return events, nil
+}
+
+// streamPVCBindEvents sends an event for every PVC that transitions to Bound
+// till the context is canceled. The watch is resumed from the last seen resource
+// version if it is closed by the server, and from a new list of the PVCs if it
+// returns an error.
+func streamPVCBindEvents(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	listOptions metav1.ListOptions,
+	watcher watch.Interface,
+	events chan<- PVCBindEvent,
+) {
+	defer close(events)
+	phases := make(map[string]v1.PersistentVolumeClaimPhase)
+	for {
+		resourceVersion, relist, done := sendPVCBindEvents(ctx, watcher, phases, events)
+		watcher.Stop()
+		if done {
+			return
+		}
+		if relist {
+			if resourceVersion, done = relistPVCBindEvents(ctx, client, namespace, listOptions, phases, events); done {
+				return
+			}
+		}
+		if resourceVersion != "" {
+			listOptions.ResourceVersion = resourceVersion
+		}
+		var err error
+		watcher, err = client.CoreV1().PersistentVolumeClaims(namespace).Watch(ctx, listOptions)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendPVCBindEvents reads events from the watch till it is closed, returning the
+// last seen resource version. relist is true if the watch returned an error, and
+// done is true if the context was canceled.
+func sendPVCBindEvents(
+	ctx context.Context,
+	watcher watch.Interface,
+	phases map[string]v1.PersistentVolumeClaimPhase,
+	events chan<- PVCBindEvent,
+) (resourceVersion string, relist, done bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion, false, true
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return resourceVersion, false, false
+			}
+			if event.Type == watch.Error {
+				return "", true, false
+			}
+			pvc, ok := event.Object.(*v1.PersistentVolumeClaim)
+			if !ok {
+				continue
+			}
+			resourceVersion = pvc.ResourceVersion
+			if event.Type == watch.Deleted {
+				delete(phases, pvc.Name)
+				continue
+			}
+			if !sendPVCBindEvent(ctx, pvc, phases, events) {
+				return resourceVersion, false, true
+			}
+		}
+	}
+}
+
+// relistPVCBindEvents lists the PVCs after the watch returned an error and sends
+// events for the ones that were bound since they were last seen. The resource
+// version of the list is returned to resume the watch from. done is true if the
+// context was canceled or the PVCs couldn't be listed.
+func relistPVCBindEvents(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	listOptions metav1.ListOptions,
+	phases map[string]v1.PersistentVolumeClaimPhase,
+	events chan<- PVCBindEvent,
+) (resourceVersion string, done bool) {
+	listOptions.ResourceVersion = ""
+	pvcList, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, listOptions)
+	if err != nil {
+		return "", true
+	}
+	listed := make(map[string]bool)
+	for i := range pvcList.Items {
+		pvc := &pvcList.Items[i]
+		listed[pvc.Name] = true
+		if !sendPVCBindEvent(ctx, pvc, phases, events) {
+			return "", true
+		}
+	}
+	for name := range phases {
+		if !listed[name] {
+			delete(phases, name)
+		}
+	}
+	return pvcList.ResourceVersion, false
+}
+
+// sendPVCBindEvent records the phase of the PVC and sends an event if it has
+// transitioned to Bound. It returns false if the context was canceled.
+func sendPVCBindEvent(
+	ctx context.Context,
+	pvc *v1.PersistentVolumeClaim,
+	phases map[string]v1.PersistentVolumeClaimPhase,
+	events chan<- PVCBindEvent,
+) bool {
+	previous := phases[pvc.Name]
+	phases[pvc.Name] = pvc.Status.Phase
+	if pvc.Status.Phase != v1.ClaimBound || previous == v1.ClaimBound {
+		return true
+	}
+	select {
+	case events <- PVCBindEvent{PVC: pvc, Phase: pvc.Status.Phase}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// WaitForPVCsBound waits till all the PVCs in the namespace that match the given
+// labels are bound, including PVCs created while waiting. A single watch is used
+// instead of polling, which is re-established after listing the PVCs again if it
+// is closed by the server. On timeout the error lists the PVCs that aren't bound.
+// Empty labels are rejected instead of waiting for every PVC in the namespace,
+// and a NamespaceNotFoundError is returned right away if the namespace doesn't
+// exist.
+func WaitForPVCsBound(namespace string, matchLabels map[string]string, timeout time.Duration) error {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, false); err != nil {
+		return err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return err
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return waitForPVCsBound(ctx, client, namespace, labels.SelectorFromSet(matchLabels))
+}
+
+func waitForPVCsBound(ctx context.Context, client kubernetes.Interface, namespace string, selector labels.Selector) error {
+	phases := make(map[string]v1.PersistentVolumeClaimPhase)
+	for {
+		// List to reconcile any changes missed while the watch was down
+		pvcList, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			return getPVCsBoundError(ctx, namespace, phases, err)
+		}
+		phases = make(map[string]v1.PersistentVolumeClaimPhase)
+		for _, pvc := range pvcList.Items {
+			phases[pvc.Name] = pvc.Status.Phase
+		}
+		if arePVCsBound(phases) {
+			return nil
+		}
+
+		watcher, err := client.CoreV1().PersistentVolumeClaims(namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector:   selector.String(),
+			ResourceVersion: pvcList.ResourceVersion,
+		})
+		if err != nil {
+			return getPVCsBoundError(ctx, namespace, phases, err)
+		}
+		bound, err := watchPVCsBound(ctx, watcher, phases)
+		watcher.Stop()
+		if bound {
+			return nil
+		} else if err != nil {
+			return getPVCsBoundError(ctx, namespace, phases, err)
+		}
+	}
+}
+
+// watchPVCsBound updates the phases of the PVCs from the watch events till all of
+// them are bound or the watch is closed
+func watchPVCsBound(ctx context.Context, watcher watch.Interface, phases map[string]v1.PersistentVolumeClaimPhase) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			if event.Type == watch.Error {
+				// Usually an expired resource version, list again to resume
+				return false, nil
+			}
+			pvc, ok := event.Object.(*v1.PersistentVolumeClaim)
+			if !ok {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				delete(phases, pvc.Name)
+			} else {
+				phases[pvc.Name] = pvc.Status.Phase
+			}
+			if arePVCsBound(phases) {
+				return true, nil
+			}
+		}
+	}
+}
+
+// arePVCsBound returns true if there is at least one PVC and all of them are bound
+func arePVCsBound(phases map[string]v1.PersistentVolumeClaimPhase) bool {
+	if len(phases) == 0 {
+		return false
+	}
+	for _, phase := range phases {
+		if phase != v1.ClaimBound {
+			return false
+		}
+	}
+	return true
+}
+
+// getPVCsBoundError returns the error for waiting for PVCs to be bound, listing
+// the PVCs that aren't bound if the wait timed out
+func getPVCsBoundError(ctx context.Context, namespace string, phases map[string]v1.PersistentVolumeClaimPhase, err error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return fmt.Errorf("error waiting for PVCs in namespace %s to be bound: %v", namespace, err)
+	}
+	if len(phases) == 0 {
+		return fmt.Errorf("timed out waiting for PVCs in namespace %s to be bound: found no PVCs", namespace)
+	}
+	unbound := make([]string, 0)
+	for name, phase := range phases {
+		if phase != v1.ClaimBound {
+			unbound = append(unbound, fmt.Sprintf("%s (%s)", name, phase))
+		}
+	}
+	sort.Strings(unbound)
+	return fmt.Errorf("timed out waiting for PVCs in namespace %s to be bound: %s", namespace, strings.Join(unbound, ", "))
+}
@@ -0,0 +1,518 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newPVC(namespace, name, volumeName string) v1.PersistentVolumeClaim {
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: volumeName,
+		},
+	}
+}
+
+func newGroupSnapshot(namespace, name string, uid types.UID) *unstructured.Unstructured {
+	groupSnapshot := &unstructured.Unstructured{}
+	groupSnapshot.SetAPIVersion(groupVolumeSnapshotGVR.GroupVersion().String())
+	groupSnapshot.SetKind("GroupVolumeSnapshot")
+	groupSnapshot.SetNamespace(namespace)
+	groupSnapshot.SetName(name)
+	groupSnapshot.SetUID(uid)
+	return groupSnapshot
+}
+
+// newGroupSnapshotMember returns a VolumeSnapshot like the ones created by the
+// group snapshot controller, owned by the group snapshot with the given UID
+func newGroupSnapshotMember(namespace, name, pvcName string, ownerUID types.UID, ready bool) *unstructured.Unstructured {
+	member := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"persistentVolumeClaimName": pvcName,
+			"snapshotDataName":          name,
+		},
+	}}
+	member.SetAPIVersion(groupSnapshotMemberGVR.GroupVersion().String())
+	member.SetKind("VolumeSnapshot")
+	member.SetNamespace(namespace)
+	member.SetName(name)
+	if ownerUID != "" {
+		member.SetOwnerReferences([]metav1.OwnerReference{{Name: "group", UID: ownerUID}})
+	}
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	member.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": status},
+		},
+	}
+	return member
+}
+
+// setFakeDynamicInstance makes the functions in this package use a fake dynamic
+// client with the given group snapshots and VolumeSnapshots, along with fake
+// kubernetes clients with the given objects, till the test ends
+func setFakeDynamicInstance(t *testing.T, kubeObjects []runtime.Object, objects ...runtime.Object) dynamic.Interface {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			groupVolumeSnapshotGVR: "GroupVolumeSnapshotList",
+			groupSnapshotMemberGVR: "VolumeSnapshotList",
+		},
+		objects...,
+	)
+	SetInstance(NewForClients(fake.NewSimpleClientset(kubeObjects...), nil, dynamicClient))
+	t.Cleanup(func() { SetInstance(nil) })
+	return dynamicClient
+}
+
+func TestListGroupSnapshotMembers(t *testing.T) {
+	setFakeDynamicInstance(t, nil,
+		newGroupSnapshot("ns1", "group1", "uid1"),
+		newGroupSnapshot("ns1", "group2", "uid2"),
+		newGroupSnapshotMember("ns1", "group1-pvc2-uid1", "pvc2", "uid1", true),
+		newGroupSnapshotMember("ns1", "group1-pvc1-uid1", "pvc1", "uid1", false),
+		newGroupSnapshotMember("ns1", "group2-pvc1-uid2", "pvc1", "uid2", true),
+		newGroupSnapshotMember("ns1", "standalone", "pvc1", "", true),
+	)
+
+	members, err := ListGroupSnapshotMembers("ns1", "group1")
+	require.NoError(t, err)
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		names = append(names, member.GetName())
+	}
+	require.Equal(t, []string{"group1-pvc1-uid1", "group1-pvc2-uid1"}, names, "Expected only the snapshots owned by the group")
+
+	_, err = ListGroupSnapshotMembers("ns1", "missing")
+	require.Error(t, err, "Expected an error for a missing group snapshot")
+}
+
+func TestGroupSnapshotFingerprint(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc2 := newPVC("ns1", "pvc2", "pv2")
+
+	require.Equal(t, GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{pvc1, pvc2}),
+		GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{pvc2, pvc1}),
+		"Fingerprint should not depend on order")
+	require.NotEqual(t, GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{pvc1}),
+		GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{pvc1, pvc2}),
+		"Fingerprint should change when a PVC is added")
+	require.NotEqual(t, GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{pvc1}),
+		GroupSnapshotFingerprint([]v1.PersistentVolumeClaim{newPVC("ns1", "pvc1", "pv3")}),
+		"Fingerprint should change when the volume changes")
+}
+
+func TestDiffPVCGroups(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc2 := newPVC("ns1", "pvc2", "pv2")
+	pvc3 := newPVC("ns2", "pvc1", "pv3")
+
+	added, removed := DiffPVCGroups([]v1.PersistentVolumeClaim{pvc1, pvc2}, []v1.PersistentVolumeClaim{pvc2, pvc3})
+	require.Len(t, added, 1)
+	require.Equal(t, "ns2", added[0].Namespace)
+	require.Len(t, removed, 1)
+	require.Equal(t, "pvc1", removed[0].Name)
+	require.Equal(t, "ns1", removed[0].Namespace)
+
+	added, removed = DiffPVCGroups(nil, nil)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+}
+
+func TestDetectPVCNameCollisions(t *testing.T) {
+	pvcs := []v1.PersistentVolumeClaim{
+		newPVC("ns1", "data", "pv1"),
+		newPVC("ns2", "data", "pv2"),
+		newPVC("ns1", "logs", "pv3"),
+	}
+	collisions := DetectPVCNameCollisions(pvcs)
+	require.Len(t, collisions, 1)
+	require.Equal(t, []string{"ns1", "ns2"}, collisions["data"])
+	require.Empty(t, DetectPVCNameCollisions(pvcs[:1]))
+}
+
+func TestOrderPVCsForSnapshot(t *testing.T) {
+	orderAnnotation := "example.com/snapshot-order"
+	wal := newPVC("ns", "wal", "pv1")
+	wal.Annotations = map[string]string{orderAnnotation: "1"}
+	data := newPVC("ns", "data", "pv2")
+	data.Annotations = map[string]string{orderAnnotation: "2"}
+	logs := newPVC("ns", "logs", "pv3")
+	cache := newPVC("ns", "cache", "pv4")
+
+	ordered, err := OrderPVCsForSnapshot([]v1.PersistentVolumeClaim{logs, data, cache, wal}, orderAnnotation)
+	require.NoError(t, err)
+	names := make([]string, 0, len(ordered))
+	for _, pvc := range ordered {
+		names = append(names, pvc.Name)
+	}
+	require.Equal(t, []string{"wal", "data", "logs", "cache"}, names)
+
+	cache.Annotations = map[string]string{orderAnnotation: "first"}
+	_, err = OrderPVCsForSnapshot([]v1.PersistentVolumeClaim{wal, cache}, orderAnnotation)
+	require.Error(t, err)
+}
+
+func TestIsSnapshottablePV(t *testing.T) {
+	newPV := func(source v1.PersistentVolumeSource) *v1.PersistentVolume {
+		return &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: source}}
+	}
+
+	require.True(t, isSnapshottablePV(newPV(v1.PersistentVolumeSource{
+		CSI: &v1.CSIPersistentVolumeSource{Driver: "pxd.portworx.com"},
+	})), "CSI volumes should be snapshottable")
+	require.True(t, isSnapshottablePV(newPV(v1.PersistentVolumeSource{
+		PortworxVolume: &v1.PortworxVolumeSource{VolumeID: "vol1"},
+	})), "Portworx volumes should be snapshottable")
+	require.False(t, isSnapshottablePV(newPV(v1.PersistentVolumeSource{
+		HostPath: &v1.HostPathVolumeSource{Path: "/data"},
+	})), "hostPath volumes should not be snapshottable")
+	require.False(t, isSnapshottablePV(newPV(v1.PersistentVolumeSource{
+		Local: &v1.LocalVolumeSource{Path: "/mnt/disk1"},
+	})), "local volumes should not be snapshottable")
+	require.False(t, isSnapshottablePV(newPV(v1.PersistentVolumeSource{
+		NFS: &v1.NFSVolumeSource{Server: "nfs", Path: "/export"},
+	})), "NFS volumes should not be snapshottable")
+}
+
+func TestGetGroupSnapshotSelector(t *testing.T) {
+	selector, err := getGroupSnapshotSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "mysql"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"gold", "silver"}},
+			{Key: "backup", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"false"}},
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, selector.Matches(labels.Set{"app": "mysql", "tier": "gold"}))
+	require.False(t, selector.Matches(labels.Set{"app": "mysql", "tier": "bronze"}))
+	require.False(t, selector.Matches(labels.Set{"app": "mysql", "tier": "gold", "backup": "false"}))
+
+	_, err = getGroupSnapshotSelector(nil)
+	require.Error(t, err, "Expected error for nil selector")
+	_, err = getGroupSnapshotSelector(&metav1.LabelSelector{})
+	require.Error(t, err, "Expected error for empty selector")
+
+	_, err = getGroupSnapshotSelector(&metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: "Like", Values: []string{"gold"}},
+		},
+	})
+	require.Error(t, err, "Expected error for invalid operator")
+}
+
+func TestCheckGroupSnapshotMatchLabels(t *testing.T) {
+	require.Error(t, checkGroupSnapshotMatchLabels(nil, false), "Expected error for nil matchLabels")
+	require.Error(t, checkGroupSnapshotMatchLabels(map[string]string{}, false), "Expected error for empty matchLabels")
+	require.NoError(t, checkGroupSnapshotMatchLabels(nil, true), "Expected empty matchLabels to be allowed")
+	require.NoError(t, checkGroupSnapshotMatchLabels(map[string]string{"app": "mysql"}, false))
+
+	// Empty matchLabels are rejected before any client calls are made
+	_, err := GetPVCsForGroupSnapshot("ns1", nil)
+	require.EqualError(t, err, "matchLabels for group snapshot are empty, which would select every PVC")
+	_, _, err = GetPVCsForGroupSnapshotWithOptions("ns1", nil, GroupSnapshotPVCOptions{SkipPending: true})
+	require.EqualError(t, err, "matchLabels for group snapshot are empty, which would select every PVC")
+}
+
+func TestSplitPendingPVCs(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc1.Status.Phase = v1.ClaimBound
+	pvc2 := newPVC("ns1", "pvc2", "")
+	pvc2.Status.Phase = v1.ClaimPending
+	pvc3 := newPVC("ns1", "pvc3", "pv3")
+	pvc3.Status.Phase = v1.ClaimBound
+
+	bound, pending := splitPendingPVCs([]v1.PersistentVolumeClaim{pvc1, pvc2, pvc3})
+	require.Equal(t, []v1.PersistentVolumeClaim{pvc1, pvc3}, bound)
+	require.Equal(t, []v1.PersistentVolumeClaim{pvc2}, pending)
+
+	bound, pending = splitPendingPVCs(nil)
+	require.Empty(t, bound)
+	require.Empty(t, pending)
+}
+
+func TestPendingPVCError(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "")
+	pvc1.Status.Phase = v1.ClaimPending
+	pvc2 := newPVC("ns1", "pvc2", "")
+	pvc2.Status.Phase = v1.ClaimPending
+
+	var err error = newPendingPVCError([]v1.PersistentVolumeClaim{pvc1})
+	require.True(t, IsPendingPVCError(err))
+	require.EqualError(t, err, "PVC: [ns1] pvc1 is still in Pending phase. Group snapshot will trigger after all PVCs are bound")
+
+	err = newPendingPVCError([]v1.PersistentVolumeClaim{pvc1, pvc2})
+	require.True(t, IsPendingPVCError(err))
+	require.Len(t, err.(*PendingPVCError).PVCs, 2)
+	require.EqualError(t, err, "PVCs: [ns1] pvc1, [ns1] pvc2 are still in Pending phase. Group snapshot will trigger after all PVCs are bound")
+
+	require.False(t, IsPendingPVCError(fmt.Errorf("PVC: [ns1] pvc1 is still in Pending phase")))
+	require.False(t, IsPendingPVCError(nil))
+}
+
+func TestGetPVProvisioner(t *testing.T) {
+	pv := &v1.PersistentVolume{}
+	require.Empty(t, getPVProvisioner(pv), "Expected no provisioner for a manually created PV")
+
+	pv.Annotations = map[string]string{pvProvisionedByAnnotation: "kubernetes.io/portworx-volume"}
+	require.Equal(t, "kubernetes.io/portworx-volume", getPVProvisioner(pv))
+
+	pv.Spec.CSI = &v1.CSIPersistentVolumeSource{Driver: "pxd.portworx.com"}
+	require.Equal(t, "pxd.portworx.com", getPVProvisioner(pv))
+}
+
+func TestGetLatestEventReasons(t *testing.T) {
+	now := time.Now()
+	newEvent := func(name, reason, message string, timestamp time.Time) v1.Event {
+		return v1.Event{
+			InvolvedObject: v1.ObjectReference{Kind: "PersistentVolumeClaim", Name: name},
+			Reason:         reason,
+			Message:        message,
+			LastTimestamp:  metav1.NewTime(timestamp),
+		}
+	}
+	reasons := getLatestEventReasons([]v1.Event{
+		newEvent("pvc1", "ProvisioningFailed", "storageclass not found", now),
+		newEvent("pvc1", "Provisioning", "waiting for volume", now.Add(-time.Minute)),
+		newEvent("pvc2", "WaitForFirstConsumer", "waiting for first consumer", now),
+	})
+	require.Equal(t, map[string]string{
+		"pvc1": "ProvisioningFailed: storageclass not found",
+		"pvc2": "WaitForFirstConsumer: waiting for first consumer",
+	}, reasons)
+	require.Empty(t, getLatestEventReasons(nil))
+}
+
+func TestWaitForPVCsBound(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc1.Labels = map[string]string{"app": "mysql"}
+	pvc1.Status.Phase = v1.ClaimBound
+	pvc2 := newPVC("ns1", "pvc2", "")
+	pvc2.Labels = map[string]string{"app": "mysql"}
+	pvc2.Status.Phase = v1.ClaimPending
+	client := fake.NewSimpleClientset(&pvc1, &pvc2)
+	selector := labels.SelectorFromSet(labels.Set{"app": "mysql"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err := waitForPVCsBound(ctx, client, "ns1", selector)
+	cancel()
+	require.EqualError(t, err, "timed out waiting for PVCs in namespace ns1 to be bound: pvc2 (Pending)")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error)
+	go func() {
+		errCh <- waitForPVCsBound(ctx, client, "ns1", selector)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pvc2.Status.Phase = v1.ClaimBound
+	_, err = client.CoreV1().PersistentVolumeClaims("ns1").Update(context.TODO(), &pvc2, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+
+	require.False(t, arePVCsBound(nil), "Expected no PVCs to not be bound")
+
+	err = WaitForPVCsBound("ns1", nil, time.Second)
+	require.EqualError(t, err, "matchLabels for group snapshot are empty, which would select every PVC")
+
+	SetInstance(NewForClients(client, nil, nil))
+	t.Cleanup(func() { SetInstance(nil) })
+	err = WaitForPVCsBound("ns1", map[string]string{"app": "mysql"}, time.Minute)
+	require.True(t, IsNamespaceNotFound(err), "Expected NamespaceNotFoundError, got %v", err)
+}
+
+func TestWatchGroupSnapshotPVCs(t *testing.T) {
+	_, err := WatchGroupSnapshotPVCs(context.TODO(), "ns1", nil)
+	require.EqualError(t, err, "matchLabels for group snapshot are empty, which would select every PVC")
+
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc1.Labels = map[string]string{"app": "mysql"}
+	pvc1.Status.Phase = v1.ClaimBound
+	client := fake.NewSimpleClientset(&pvc1)
+	watchers := []*watch.FakeWatcher{watch.NewFake(), watch.NewFake()}
+	watchCalls := 0
+	client.PrependWatchReactor("persistentvolumeclaims", func(k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watchers[watchCalls]
+		watchCalls++
+		return true, watcher, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := watchPVCBindEvents(ctx, client, "ns1", metav1.ListOptions{LabelSelector: "app=mysql"})
+	require.NoError(t, err)
+
+	// The PVC is bound while the watch is broken, so the event comes from the relist
+	pending := pvc1.DeepCopy()
+	pending.Status.Phase = v1.ClaimPending
+	watchers[0].Add(pending)
+	watchers[0].Error(&metav1.Status{Reason: metav1.StatusReasonExpired})
+	event := <-events
+	require.Equal(t, "pvc1", event.PVC.Name)
+	require.Equal(t, v1.ClaimBound, event.Phase)
+
+	pvc2 := newPVC("ns1", "pvc2", "pv2")
+	pvc2.Status.Phase = v1.ClaimBound
+	watchers[1].Add(&pvc2)
+	event = <-events
+	require.Equal(t, "pvc2", event.PVC.Name)
+	require.Equal(t, 2, watchCalls)
+
+	cancel()
+	_, ok := <-events
+	require.False(t, ok, "Expected events to be closed after the context is canceled")
+}
+
+func TestGetSingleProvisioner(t *testing.T) {
+	provisioner, err := getSingleProvisioner("ns1", map[string][]string{
+		"pxd.portworx.com": {"pvc1", "pvc2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "pxd.portworx.com", provisioner)
+
+	_, err = getSingleProvisioner("ns1", map[string][]string{
+		"pxd.portworx.com": {"pvc2", "pvc1"},
+		"ebs.csi.aws.com":  {"pvc3"},
+		noProvisioner:      {"pvc4"},
+	})
+	require.EqualError(t, err, "PVCs for group snapshot in namespace ns1 have different provisioners: "+
+		"ebs.csi.aws.com: [pvc3], none: [pvc4], pxd.portworx.com: [pvc1, pvc2]")
+}
+
+func TestWaitForGroupSnapshotReady(t *testing.T) {
+	setFakeDynamicInstance(t, nil,
+		newGroupSnapshot("ns1", "group1", "uid1"),
+		newGroupSnapshotMember("ns1", "group1-pvc1-uid1", "pvc1", "uid1", true),
+		newGroupSnapshotMember("ns1", "group1-pvc2-uid1", "pvc2", "uid1", true),
+		newGroupSnapshot("ns1", "group2", "uid2"),
+		newGroupSnapshotMember("ns1", "group2-pvc1-uid2", "pvc1", "uid2", true),
+		newGroupSnapshotMember("ns1", "group2-pvc2-uid2", "pvc2", "uid2", false),
+	)
+
+	require.NoError(t, WaitForGroupSnapshotReady("ns1", "group1", 2, time.Second))
+
+	err := WaitForGroupSnapshotReady("ns1", "group1", 3, time.Second)
+	require.EqualError(t, err, "timed out waiting for group snapshot [ns1] group1: found 2 of 3 members, not ready: []")
+
+	err = WaitForGroupSnapshotReady("ns1", "group2", 2, time.Second)
+	require.EqualError(t, err, "timed out waiting for group snapshot [ns1] group2: members not ready: [group2-pvc2-uid2]")
+}
+
+func TestBuildRestorePVCs(t *testing.T) {
+	block := v1.PersistentVolumeBlock
+	source := newPVC("ns1", "pvc1", "pv1")
+	source.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}
+	source.Spec.VolumeMode = &block
+	source.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse("2Gi")}
+	setFakeDynamicInstance(t, []runtime.Object{&source},
+		newGroupSnapshot("ns1", "group1", "uid1"),
+		newGroupSnapshotMember("ns1", "group1-pvc1-uid1", "pvc1", "uid1", true),
+		newGroupSnapshot("ns1", "group2", "uid2"),
+		newGroupSnapshotMember("ns1", "group2-pvc2-uid2", "pvc2", "uid2", true),
+		newGroupSnapshot("ns1", "empty", "uid3"),
+	)
+
+	pvcs, err := BuildRestorePVCs("ns1", "group1", "")
+	require.NoError(t, err)
+	require.Len(t, pvcs, 1)
+	pvc := pvcs[0]
+	require.Equal(t, "pvc1-restore", pvc.Name)
+	require.Equal(t, "group1-pvc1-uid1", pvc.Annotations["snapshot.alpha.kubernetes.io/snapshot"])
+	require.Equal(t, StorkSnapshotStorageClass, *pvc.Spec.StorageClassName)
+	require.Equal(t, source.Spec.AccessModes, pvc.Spec.AccessModes)
+	require.Equal(t, &block, pvc.Spec.VolumeMode)
+	require.Equal(t, "2Gi", pvc.Spec.Resources.Requests.Storage().String())
+
+	pvcs, err = BuildRestorePVCs("ns1", "group1", "custom-sc")
+	require.NoError(t, err)
+	require.Equal(t, "custom-sc", *pvcs[0].Spec.StorageClassName)
+
+	_, err = BuildRestorePVCs("ns1", "group2", "")
+	require.Error(t, err, "Expected an error when the source PVC is missing")
+	_, err = BuildRestorePVCs("ns1", "empty", "")
+	require.EqualError(t, err, "group snapshot [ns1] empty has no member snapshots")
+}
+
+func TestVerifyGroupSnapshotCompleteness(t *testing.T) {
+	setFakeDynamicInstance(t, nil,
+		newGroupSnapshot("ns1", "group1", "uid1"),
+		newGroupSnapshotMember("ns1", "group1-pvc1-uid1", "pvc1", "uid1", true),
+		newGroupSnapshotMember("ns1", "other-pvc2", "pvc2", "uid2", true),
+	)
+
+	expected := []v1.PersistentVolumeClaim{newPVC("ns1", "pvc3", "pv3"), newPVC("ns1", "pvc2", "pv2"), newPVC("ns1", "pvc1", "pv1")}
+	missing, err := VerifyGroupSnapshotCompleteness("ns1", "group1", expected)
+	require.NoError(t, err)
+	require.Equal(t, []string{"pvc2", "pvc3"}, missing, "Expected snapshots of other groups to not count")
+}
+
+func TestCancelGroupSnapshot(t *testing.T) {
+	newStagedGroupSnapshot := func(name string, uid types.UID, stage string) *unstructured.Unstructured {
+		groupSnapshot := newGroupSnapshot("ns1", name, uid)
+		groupSnapshot.Object["status"] = map[string]interface{}{"stage": stage, "status": "InProgress"}
+		return groupSnapshot
+	}
+	dynamicClient := setFakeDynamicInstance(t, nil,
+		newStagedGroupSnapshot("group1", "uid1", "Snapshot"),
+		newStagedGroupSnapshot("group2", "uid2", "Final"),
+	)
+
+	require.NoError(t, CancelGroupSnapshot("ns1", "group1"))
+	groupSnapshot, err := dynamicClient.Resource(groupVolumeSnapshotGVR).Namespace("ns1").Get(context.TODO(), "group1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.True(t, IsGroupSnapshotCancelled(groupSnapshot), "Expected the group snapshot to be annotated for the controller")
+	require.Equal(t, "Snapshot", groupSnapshot.Object["status"].(map[string]interface{})["stage"],
+		"Expected the status to be left to the controller")
+	require.NoError(t, CancelGroupSnapshot("ns1", "group1"), "Expected cancelling twice to succeed")
+
+	require.NoError(t, CancelGroupSnapshot("ns1", "group2"))
+	groupSnapshot, err = dynamicClient.Resource(groupVolumeSnapshotGVR).Namespace("ns1").Get(context.TODO(), "group2", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, IsGroupSnapshotCancelled(groupSnapshot), "Expected a completed group snapshot to be left alone")
+
+	require.Error(t, CancelGroupSnapshot("ns1", "missing"))
+}
+
+func TestRelabelGroupSnapshotMembers(t *testing.T) {
+	member := newGroupSnapshotMember("ns1", "group1-pvc1-uid1", "pvc1", "uid1", true)
+	member.SetLabels(map[string]string{"app": "mysql", "tier": "db"})
+	dynamicClient := setFakeDynamicInstance(t, nil,
+		newGroupSnapshot("ns1", "group1", "uid1"),
+		member,
+		newGroupSnapshotMember("ns1", "other", "pvc1", "uid2", true),
+	)
+
+	require.NoError(t, RelabelGroupSnapshotMembers("ns1", "group1", map[string]string{"app": "postgres"}))
+	member, err := dynamicClient.Resource(groupSnapshotMemberGVR).Namespace("ns1").Get(context.TODO(), "group1-pvc1-uid1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app": "postgres", "tier": "db"}, member.GetLabels())
+	other, err := dynamicClient.Resource(groupSnapshotMemberGVR).Namespace("ns1").Get(context.TODO(), "other", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, other.GetLabels(), "Expected snapshots of other groups to not be relabeled")
+}
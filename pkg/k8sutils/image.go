@@ -0,0 +1,150 @@
+package k8sutils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// imageReference is an image reference split into its parts following the
+// Docker reference grammar: [registry/]repository[:tag][@digest]
+type imageReference struct {
+	// registry host, including the port. Empty for images on Docker Hub that
+	// don't name the registry.
+	registry string
+	// repository path within the registry
+	repository string
+	tag        string
+	// digest including the algorithm, like sha256:...
+	digest string
+}
+
+// parseImageReference splits the image into its parts. The first path segment is
+// only treated as the registry if it contains a . or :, or is localhost, since
+// otherwise it is a path on Docker Hub, like openstorage/stork.
+func parseImageReference(image string) imageReference {
+	ref := imageReference{}
+	if at := strings.Index(image, "@"); at >= 0 {
+		ref.digest = image[at+1:]
+		image = image[:at]
+	}
+	if slash := strings.Index(image, "/"); slash >= 0 {
+		host := image[:slash]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.registry = host
+			image = image[slash+1:]
+		}
+	}
+	// A colon after the last slash separates the tag, any other colon was part
+	// of the registry port which has already been removed
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		ref.tag = image[colon+1:]
+		image = image[:colon]
+	}
+	ref.repository = image
+	return ref
+}
+
+// getImageTagOrDigest returns the digest of the image if it has one, otherwise
+// its tag
+func getImageTagOrDigest(image string) (string, error) {
+	ref := parseImageReference(image)
+	if ref.digest != "" {
+		return ref.digest, nil
+	}
+	if ref.tag != "" {
+		return ref.tag, nil
+	}
+	return "", fmt.Errorf("image %s has no tag or digest", image)
+}
+
+// RegistryCredential is a registry that an image pull secret has credentials
+// for. The password is left out so that the credentials can be logged.
+type RegistryCredential struct {
+	// Server is the registry server the credentials are for
+	Server string
+	// Username used to log in to the server
+	Username string
+}
+
+// dockerConfigEntry is the credentials for one registry in a docker config
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// GetRegistryCredentials returns the registries that the image pull secrets of
+// the given deployment have credentials for, so that they can be checked against
+// the registry of its images. Both kubernetes.io/dockerconfigjson and the legacy
+// kubernetes.io/dockercfg secrets are supported.
+func GetRegistryCredentials(name, namespace string) ([]RegistryCredential, error) {
+	secretNames, err := GetImagePullSecretsFromDeployment(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	creds := make([]RegistryCredential, 0)
+	for _, secretName := range secretNames {
+		secret, err := Instance().Core().GetSecret(secretName, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("error getting image pull secret [%s] %s: %v", namespace, secretName, err)
+		}
+		secretCreds, err := getRegistryCredentials(secret)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding image pull secret [%s] %s: %v", namespace, secretName, err)
+		}
+		creds = append(creds, secretCreds...)
+	}
+	return creds, nil
+}
+
+// getRegistryCredentials decodes the docker config in the given image pull
+// secret. The credentials are ordered by server.
+func getRegistryCredentials(secret *v1.Secret) ([]RegistryCredential, error) {
+	entries := make(map[string]dockerConfigEntry)
+	switch secret.Type {
+	case v1.SecretTypeDockerConfigJson:
+		config := struct {
+			Auths map[string]dockerConfigEntry `json:"auths"`
+		}{}
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigJsonKey], &config); err != nil {
+			return nil, err
+		}
+		entries = config.Auths
+	case v1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigKey], &entries); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported secret type %s", secret.Type)
+	}
+
+	creds := make([]RegistryCredential, 0, len(entries))
+	for server, entry := range entries {
+		username, err := getDockerConfigUsername(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for server %s: %v", server, err)
+		}
+		creds = append(creds, RegistryCredential{Server: server, Username: username})
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].Server < creds[j].Server
+	})
+	return creds, nil
+}
+
+// getDockerConfigUsername returns the username of the docker config entry,
+// decoding it from the base64 encoded username:password auth if it isn't set
+func getDockerConfigUsername(entry dockerConfigEntry) (string, error) {
+	if entry.Username != "" || entry.Auth == "" {
+		return entry.Username, nil
+	}
+	auth, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(string(auth), ":", 2)[0], nil
+}
@@ -0,0 +1,151 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:4f2a7e3c9b1d5a6e8f0c2b4d6a8e0c2b4d6f8a0c2e4b6d8f0a2c4e6b8d0f2a4c"
+	tests := []struct {
+		image    string
+		expected imageReference
+	}{
+		{"stork", imageReference{repository: "stork"}},
+		{"stork:2.1", imageReference{repository: "stork", tag: "2.1"}},
+		{"openstorage/stork:2.1", imageReference{repository: "openstorage/stork", tag: "2.1"}},
+		{"docker.io/openstorage/stork:2.1", imageReference{registry: "docker.io", repository: "openstorage/stork", tag: "2.1"}},
+		{"localhost/stork", imageReference{registry: "localhost", repository: "stork"}},
+		{"localhost:5000/team/subdir/stork:2.1", imageReference{registry: "localhost:5000", repository: "team/subdir/stork", tag: "2.1"}},
+		{"registry:5000/stork", imageReference{registry: "registry:5000", repository: "stork"}},
+		{"gcr.io/project/path/to/stork:latest", imageReference{registry: "gcr.io", repository: "project/path/to/stork", tag: "latest"}},
+		{"a/b/c/stork:1.0", imageReference{repository: "a/b/c/stork", tag: "1.0"}},
+		{"quay.io/openstorage/stork@" + digest, imageReference{registry: "quay.io", repository: "openstorage/stork", digest: digest}},
+		{"quay.io:443/openstorage/stork:2.1@" + digest,
+			imageReference{registry: "quay.io:443", repository: "openstorage/stork", tag: "2.1", digest: digest}},
+		{"openstorage/stork@" + digest, imageReference{repository: "openstorage/stork", digest: digest}},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, parseImageReference(test.image), "Unexpected result for %s", test.image)
+	}
+}
+
+func TestGetImagePullSecretNames(t *testing.T) {
+	deploy := &appsv1.Deployment{}
+	names := getImagePullSecretNames(deploy)
+	require.NotNil(t, names, "Expected empty slice without pull secrets")
+	require.Empty(t, names)
+
+	deploy.Spec.Template.Spec.ImagePullSecrets = []v1.LocalObjectReference{
+		{Name: "mirror"},
+		{Name: "backup"},
+	}
+	require.Equal(t, []string{"mirror", "backup"}, getImagePullSecretNames(deploy))
+}
+
+func TestGetDeploymentContainer(t *testing.T) {
+	deploy := &appsv1.Deployment{}
+	deploy.Name = "stork"
+	deploy.Namespace = "kube-system"
+	_, err := getDeploymentContainer(deploy, "stork")
+	require.EqualError(t, err, "deployment [kube-system] stork has no containers")
+
+	deploy.Spec.Template.Spec.Containers = []v1.Container{
+		{Name: "metrics", Image: "quay.io/prometheus/exporter:v1"},
+		{Name: "stork", Image: "registry.example.com:5000/openstorage/stork:2.8.0"},
+	}
+	container, err := getDeploymentContainer(deploy, "stork")
+	require.NoError(t, err)
+	require.Equal(t, "stork", container.Name)
+
+	_, err = getDeploymentContainer(deploy, "missing")
+	require.EqualError(t, err, "container missing not found in deployment [kube-system] stork")
+}
+
+func TestGetImageTagOrDigest(t *testing.T) {
+	digest := "sha256:4f2a7e3c9b1d5a6e8f0c2b4d6a8e0c2b4d6f8a0c2e4b6d8f0a2c4e6b8d0f2a4c"
+
+	tag, err := getImageTagOrDigest("openstorage/stork:2.8.0")
+	require.NoError(t, err)
+	require.Equal(t, "2.8.0", tag)
+
+	tag, err = getImageTagOrDigest("registry.example.com:5000/openstorage/stork:2.8.0")
+	require.NoError(t, err)
+	require.Equal(t, "2.8.0", tag)
+
+	tag, err = getImageTagOrDigest("registry.example.com:5000/openstorage/stork@" + digest)
+	require.NoError(t, err)
+	require.Equal(t, digest, tag)
+
+	tag, err = getImageTagOrDigest("openstorage/stork:2.8.0@" + digest)
+	require.NoError(t, err)
+	require.Equal(t, digest, tag, "Expected digest to take precedence over the tag")
+
+	_, err = getImageTagOrDigest("openstorage/stork")
+	require.Error(t, err, "Expected error for untagged image that defaults to latest")
+
+	_, err = getImageTagOrDigest("registry.example.com:5000/openstorage/stork")
+	require.Error(t, err, "Expected registry port to not be treated as a tag")
+}
+
+func TestGetRegistryCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:secret"))
+	tests := []struct {
+		name      string
+		secret    *v1.Secret
+		expected  []RegistryCredential
+		expectErr bool
+	}{
+		{
+			name: "dockerconfigjson",
+			secret: &v1.Secret{
+				Type: v1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{v1.DockerConfigJsonKey: []byte(
+					`{"auths":{"quay.io":{"username":"admin","password":"secret"},"registry.example.com:5000":{"auth":"` + auth + `"}}}`)},
+			},
+			expected: []RegistryCredential{
+				{Server: "quay.io", Username: "admin"},
+				{Server: "registry.example.com:5000", Username: "robot"},
+			},
+		},
+		{
+			name: "dockercfg",
+			secret: &v1.Secret{
+				Type: v1.SecretTypeDockercfg,
+				Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"https://index.docker.io/v1/":{"auth":"` + auth + `","email":"a@b.c"}}`)},
+			},
+			expected: []RegistryCredential{{Server: "https://index.docker.io/v1/", Username: "robot"}},
+		},
+		{
+			name:      "opaque secret",
+			secret:    &v1.Secret{Type: v1.SecretTypeOpaque},
+			expectErr: true,
+		},
+		{
+			name: "invalid auth",
+			secret: &v1.Secret{
+				Type: v1.SecretTypeDockercfg,
+				Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"quay.io":{"auth":"not base64!"}}`)},
+			},
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds, err := getRegistryCredentials(test.secret)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, creds)
+		})
+	}
+}
@@ -2,86 +2,1037 @@ package k8sutils
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/portworx/sched-ops/k8s/apiextensions"
-	"github.com/portworx/sched-ops/k8s/apps"
-	"github.com/portworx/sched-ops/k8s/core"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
 	crdTimeout    = 1 * time.Minute
 	retryInterval = 5 * time.Second
+	// defaultVolumeNameWorkers is the number of PVCs whose volume names are
+	// resolved concurrently
+	defaultVolumeNameWorkers = 8
+	// defaultPVCBoundTimeout is how long to wait for the PVCs of a group snapshot
+	// to be bound
+	defaultPVCBoundTimeout = 5 * time.Minute
+	// defaultPVCPageSize is the number of PVCs fetched per list request
+	defaultPVCPageSize = 500
 	// StorkDeploymentName - stork deployment name
 	StorkDeploymentName = "stork"
 	storkPodLabelKey    = "name"
 	storkPodLabelValue  = "stork"
 	// DefaultAdminNamespace - default admin namespace, where stork will be installed
 	DefaultAdminNamespace = "kube-system"
+	// StorkFieldManager - field manager used by stork for server-side apply
+	StorkFieldManager = "stork"
+
+	pvcProvisionerAnnotation  = "volume.beta.kubernetes.io/storage-provisioner"
+	pvProvisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	// noProvisioner is used for PVCs whose provisioner can't be determined
+	noProvisioner = "none"
 )
 
-// GetPVCsForGroupSnapshot returns all PVCs in given namespace that match the given matchLabels. All PVCs need to be bound.
-func GetPVCsForGroupSnapshot(namespace string, matchLabels map[string]string) ([]v1.PersistentVolumeClaim, error) {
-	pvcList, err := core.Instance().GetPersistentVolumeClaims(namespace, matchLabels)
+// crdColumnTypes are the types supported for the printer columns of CRDs
+var crdColumnTypes = map[string]bool{
+	"integer": true,
+	"number":  true,
+	"string":  true,
+	"boolean": true,
+	"date":    true,
+}
+
+// serviceAccountNamespaceFile is the file in which the namespace of the pod is
+// mounted along with the service account token
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var (
+	// storkNamespaceCache is the namespace cached by GetStorkPodNamespaceCached
+	storkNamespaceCache     string
+	storkNamespaceCacheLock sync.RWMutex
+)
+
+// GetPVCsForGroupSnapshot returns all PVCs in given namespace that match the given matchLabels. All PVCs need to be bound.
+func GetPVCsForGroupSnapshot(namespace string, matchLabels map[string]string) ([]v1.PersistentVolumeClaim, error) {
+	return GetPVCsForGroupSnapshotContext(context.Background(), namespace, matchLabels)
+}
+
+// GetPVCsForGroupSnapshotContext is GetPVCsForGroupSnapshot with a context for the client calls
+func GetPVCsForGroupSnapshotContext(ctx context.Context, namespace string, matchLabels map[string]string) ([]v1.PersistentVolumeClaim, error) {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, false); err != nil {
+		return nil, err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, err
+	}
+	return listPVCsForGroupSnapshot(ctx, namespace, labels.SelectorFromSet(matchLabels), matchLabels)
+}
+
+// GetPVCsForGroupSnapshotSelector returns all PVCs in given namespace that match
+// the given label selector, which can use matchLabels and matchExpressions. All
+// PVCs need to be bound. An empty selector is rejected instead of selecting every
+// PVC in the namespace.
+func GetPVCsForGroupSnapshotSelector(namespace string, selector *metav1.LabelSelector) ([]v1.PersistentVolumeClaim, error) {
+	return GetPVCsForGroupSnapshotSelectorContext(context.Background(), namespace, selector)
+}
+
+// GetPVCsForGroupSnapshotSelectorContext is GetPVCsForGroupSnapshotSelector with a
+// context for the client calls
+func GetPVCsForGroupSnapshotSelectorContext(ctx context.Context, namespace string, selector *metav1.LabelSelector) ([]v1.PersistentVolumeClaim, error) {
+	labelSelector, err := getGroupSnapshotSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, err
+	}
+	return listPVCsForGroupSnapshot(ctx, namespace, labelSelector, labelSelector.String())
+}
+
+// GetPVCsForGroupSnapshotMultiNamespace returns all PVCs in the given namespaces
+// that match the given matchLabels. PVCs in all namespaces are returned if no
+// namespaces are given. All PVCs need to be bound.
+func GetPVCsForGroupSnapshotMultiNamespace(namespaces []string, matchLabels map[string]string) ([]v1.PersistentVolumeClaim, error) {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, false); err != nil {
+		return nil, err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	if len(namespaces) == 0 {
+		return listPVCsForGroupSnapshot(context.Background(), metav1.NamespaceAll, labels.SelectorFromSet(matchLabels), matchLabels)
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	for _, namespace := range namespaces {
+		if err := EnsureNamespaceExists(namespace); err != nil {
+			return nil, err
+		}
+		pvcList, err := client.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(matchLabels).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing PVCs in namespace %s for group snapshot: %v", namespace, err)
+		}
+		pvcs = append(pvcs, pvcList.Items...)
+	}
+
+	if len(pvcs) == 0 {
+		return nil, fmt.Errorf("found no PVCs for group snapshot in namespaces [%s] with given label selectors: %v",
+			strings.Join(namespaces, ", "), matchLabels)
+	}
+	if _, pending := splitPendingPVCs(pvcs); len(pending) > 0 {
+		return nil, newPendingPVCError(pending)
+	}
+	return pvcs, nil
+}
+
+// GetPVCsForGroupSnapshotByProvisioner returns the PVCs in the given namespace
+// that match the given matchLabels and are provisioned by one of the given
+// provisioners, along with the matching PVCs that were excluded. The provisioner
+// is taken from the storage class of the PVC, falling back to the bound PV for
+// PVCs without a storage class. All PVCs need to be bound.
+func GetPVCsForGroupSnapshotByProvisioner(
+	namespace string,
+	matchLabels map[string]string,
+	provisioners []string,
+) ([]v1.PersistentVolumeClaim, []v1.PersistentVolumeClaim, error) {
+	pvcs, err := GetPVCsForGroupSnapshot(namespace, matchLabels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, provisioner := range provisioners {
+		allowed[provisioner] = true
+	}
+	scProvisioners := make(map[string]string)
+	included := make([]v1.PersistentVolumeClaim, 0)
+	excluded := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcs {
+		provisioner, err := getPVCProvisioner(&pvc, scProvisioners)
+		if err != nil {
+			return nil, nil, err
+		}
+		if allowed[provisioner] {
+			included = append(included, pvc)
+		} else {
+			excluded = append(excluded, pvc)
+		}
+	}
+	return included, excluded, nil
+}
+
+// ValidateSingleProvisioner checks that all the PVCs in the given namespace that
+// match the given matchLabels have the same provisioner, and returns it. PVCs
+// whose provisioner can't be determined, like pre-provisioned PVCs without a
+// storage class, are grouped under "none".
+func ValidateSingleProvisioner(namespace string, matchLabels map[string]string) (string, error) {
+	pvcs, err := GetPVCsForGroupSnapshot(namespace, matchLabels)
+	if err != nil {
+		return "", err
+	}
+
+	scProvisioners := make(map[string]string)
+	groups := make(map[string][]string)
+	for _, pvc := range pvcs {
+		provisioner, err := getPVCProvisioner(&pvc, scProvisioners)
+		if err != nil {
+			return "", err
+		}
+		if provisioner == "" {
+			provisioner = noProvisioner
+		}
+		groups[provisioner] = append(groups[provisioner], pvc.Name)
+	}
+	return getSingleProvisioner(namespace, groups)
+}
+
+// getSingleProvisioner returns the only provisioner in the given PVC names
+// grouped by provisioner, or an error listing all of them if there are several
+func getSingleProvisioner(namespace string, groups map[string][]string) (string, error) {
+	if len(groups) == 1 {
+		for provisioner := range groups {
+			return provisioner, nil
+		}
+	}
+	provisioners := make([]string, 0, len(groups))
+	for provisioner, names := range groups {
+		sort.Strings(names)
+		provisioners = append(provisioners, fmt.Sprintf("%s: [%s]", provisioner, strings.Join(names, ", ")))
+	}
+	sort.Strings(provisioners)
+	return "", fmt.Errorf("PVCs for group snapshot in namespace %s have different provisioners: %s",
+		namespace, strings.Join(provisioners, ", "))
+}
+
+// getPVCProvisioner returns the provisioner of the PVC's storage class, caching
+// the provisioner of each storage class in scProvisioners. For PVCs without a
+// storage class the provisioner is taken from the bound PV, and is empty if it
+// can't be determined.
+func getPVCProvisioner(pvc *v1.PersistentVolumeClaim, scProvisioners map[string]string) (string, error) {
+	scName := ""
+	if pvc.Spec.StorageClassName != nil {
+		scName = *pvc.Spec.StorageClassName
+	}
+	if scName == "" {
+		scName = pvc.Annotations[v1.BetaStorageClassAnnotation]
+	}
+	if scName != "" {
+		if provisioner, ok := scProvisioners[scName]; ok {
+			return provisioner, nil
+		}
+		sc, err := Instance().Storage().GetStorageClass(scName)
+		if err != nil {
+			return "", fmt.Errorf("error getting storage class %s for PVC [%s] %s: %v", scName, pvc.Namespace, pvc.Name, err)
+		}
+		scProvisioners[scName] = sc.Provisioner
+		return sc.Provisioner, nil
+	}
+
+	if provisioner := pvc.Annotations[pvcProvisionerAnnotation]; provisioner != "" {
+		return provisioner, nil
+	}
+	if pvc.Spec.VolumeName == "" {
+		return "", nil
+	}
+	pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+	if err != nil {
+		return "", fmt.Errorf("error getting PV %s for PVC [%s] %s: %v", pvc.Spec.VolumeName, pvc.Namespace, pvc.Name, err)
+	}
+	return getPVProvisioner(pv), nil
+}
+
+// getPVProvisioner returns the CSI driver of the PV, or the provisioner it was
+// annotated with. Empty if neither is set, like for manually created PVs.
+func getPVProvisioner(pv *v1.PersistentVolume) string {
+	if pv.Spec.CSI != nil {
+		return pv.Spec.CSI.Driver
+	}
+	return pv.Annotations[pvProvisionedByAnnotation]
+}
+
+// checkGroupSnapshotMatchLabels returns an error if the given matchLabels are
+// empty, since they would select every PVC in the namespace, unless allowEmpty
+// is set
+func checkGroupSnapshotMatchLabels(matchLabels map[string]string, allowEmpty bool) error {
+	if len(matchLabels) == 0 && !allowEmpty {
+		return fmt.Errorf("matchLabels for group snapshot are empty, which would select every PVC")
+	}
+	return nil
+}
+
+// getGroupSnapshotSelector converts the given label selector for listing the
+// PVCs of a group snapshot
+func getGroupSnapshotSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if isEmptyLabelSelector(selector) {
+		return nil, fmt.Errorf("label selector for group snapshot is empty")
+	}
+	if selector.MatchLabels != nil {
+		matchLabels, err := NormalizeMatchLabels(selector.MatchLabels)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.DeepCopy()
+		selector.MatchLabels = matchLabels
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector for group snapshot: %v", err)
+	}
+	return labelSelector, nil
+}
+
+// listPVCsForGroupSnapshot lists the PVCs in the given namespace that match the
+// selector and checks that they are all bound
+func listPVCsForGroupSnapshot(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+	description interface{},
+) ([]v1.PersistentVolumeClaim, error) {
+	return listPVCsForGroupSnapshotPaged(ctx, namespace, selector, description, defaultPVCPageSize)
+}
+
+// listPVCsForGroupSnapshotPaged is listPVCsForGroupSnapshot listing the PVCs in
+// pages of the given size. Each page is checked for pending PVCs as it is listed.
+func listPVCsForGroupSnapshotPaged(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+	description interface{},
+	pageSize int64,
+) ([]v1.PersistentVolumeClaim, error) {
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	err := listPVCPages(ctx, namespace, selector, pageSize, func(page []v1.PersistentVolumeClaim) error {
+		// Check if no PVCs are in pending state
+		for _, pvc := range page {
+			if pvc.Status.Phase == v1.ClaimPending {
+				return newPendingPVCError([]v1.PersistentVolumeClaim{pvc})
+			}
+		}
+		pvcs = append(pvcs, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pvcs) == 0 {
+		return nil, fmt.Errorf("found no PVCs for group snapshot with given label selectors: %v", description)
+	}
+	return pvcs, nil
+}
+
+// listGroupSnapshotPVCs lists the PVCs in the given namespace that match the
+// selector, returning an error if there are none
+func listGroupSnapshotPVCs(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+	description interface{},
+) ([]v1.PersistentVolumeClaim, error) {
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	err := listPVCPages(ctx, namespace, selector, defaultPVCPageSize, func(page []v1.PersistentVolumeClaim) error {
+		pvcs = append(pvcs, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pvcs) == 0 {
+		return nil, fmt.Errorf("found no PVCs for group snapshot with given label selectors: %v", description)
+	}
+	return pvcs, nil
+}
+
+// listPVCPages lists the PVCs in the given namespace that match the selector in
+// pages of the given size, calling fn for each page. Listing stops if fn returns
+// an error.
+func listPVCPages(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+	pageSize int64,
+	fn func([]v1.PersistentVolumeClaim) error,
+) error {
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPVCPageSize
+	}
+	opts := metav1.ListOptions{
+		LabelSelector: selector.String(),
+		Limit:         pageSize,
+	}
+	for {
+		pvcList, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if err := fn(pvcList.Items); err != nil {
+			return err
+		}
+		if pvcList.Continue == "" {
+			return nil
+		}
+		opts.Continue = pvcList.Continue
+	}
+}
+
+// GetPVCsForGroupSnapshotPaged is GetPVCsForGroupSnapshot listing the PVCs in
+// pages of the given size, to bound the size of the responses in namespaces with
+// a large number of PVCs
+func GetPVCsForGroupSnapshotPaged(namespace string, matchLabels map[string]string, pageSize int64) ([]v1.PersistentVolumeClaim, error) {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, false); err != nil {
+		return nil, err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, err
+	}
+	return listPVCsForGroupSnapshotPaged(context.Background(), namespace, labels.SelectorFromSet(matchLabels), matchLabels, pageSize)
+}
+
+// UnboundPVC has the details of a PVC that isn't bound, for troubleshooting
+// group snapshots that don't trigger
+type UnboundPVC struct {
+	// Namespace of the PVC
+	Namespace string
+	// Name of the PVC
+	Name string
+	// Phase of the PVC, Pending or Lost
+	Phase v1.PersistentVolumeClaimPhase
+	// StorageClass requested by the PVC, if any
+	StorageClass string
+	// Reason from the latest event for the PVC, if any
+	Reason string
+}
+
+// GetUnboundPVCs returns all PVCs in the given namespace that match the given
+// matchLabels and aren't bound
+func GetUnboundPVCs(namespace string, matchLabels map[string]string) ([]v1.PersistentVolumeClaim, error) {
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, err
+	}
+	pvcs, err := listGroupSnapshotPVCs(context.Background(), namespace, labels.SelectorFromSet(matchLabels), matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	unbound := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase != v1.ClaimBound {
+			unbound = append(unbound, pvc)
+		}
+	}
+	return unbound, nil
+}
+
+// GetUnboundPVCDiagnostics returns the details of all PVCs in the given namespace
+// that match the given matchLabels and aren't bound. The reason is taken from the
+// latest event for each PVC and is left empty if the events can't be listed.
+func GetUnboundPVCDiagnostics(namespace string, matchLabels map[string]string) ([]UnboundPVC, error) {
+	pvcs, err := GetUnboundPVCs(namespace, matchLabels)
+	if err != nil {
+		return nil, err
+	}
+	reasons := make(map[string]string)
+	if len(pvcs) > 0 {
+		events, err := Instance().Core().ListEvents(namespace, metav1.ListOptions{
+			FieldSelector: "involvedObject.kind=PersistentVolumeClaim",
+		})
+		if err == nil {
+			reasons = getLatestEventReasons(events.Items)
+		}
+	}
+
+	unbound := make([]UnboundPVC, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		info := UnboundPVC{
+			Namespace: pvc.Namespace,
+			Name:      pvc.Name,
+			Phase:     pvc.Status.Phase,
+			Reason:    reasons[pvc.Name],
+		}
+		if pvc.Spec.StorageClassName != nil {
+			info.StorageClass = *pvc.Spec.StorageClassName
+		} else {
+			info.StorageClass = pvc.Annotations[v1.BetaStorageClassAnnotation]
+		}
+		unbound = append(unbound, info)
+	}
+	return unbound, nil
+}
+
+// getLatestEventReasons returns <reason>: <message> of the latest event for each
+// involved object, keyed by the name of the object
+func getLatestEventReasons(events []v1.Event) map[string]string {
+	latest := make(map[string]v1.Event)
+	for _, event := range events {
+		name := event.InvolvedObject.Name
+		if current, ok := latest[name]; !ok || getEventTime(event).After(getEventTime(current)) {
+			latest[name] = event
+		}
+	}
+	reasons := make(map[string]string, len(latest))
+	for name, event := range latest {
+		reasons[name] = fmt.Sprintf("%s: %s", event.Reason, event.Message)
+	}
+	return reasons
+}
+
+// getEventTime returns the time the event was last seen
+func getEventTime(event v1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// PendingPVC is a PVC that hasn't been bound yet
+type PendingPVC struct {
+	// Namespace of the PVC
+	Namespace string
+	// Name of the PVC
+	Name string
+	// Phase of the PVC
+	Phase v1.PersistentVolumeClaimPhase
+}
+
+// PendingPVCError error type for group snapshots with PVCs that aren't bound yet
+type PendingPVCError struct {
+	// PVCs that are pending
+	PVCs []PendingPVC
+}
+
+func (e *PendingPVCError) Error() string {
+	pvcs := make([]string, 0, len(e.PVCs))
+	for _, pvc := range e.PVCs {
+		pvcs = append(pvcs, fmt.Sprintf("[%s] %s", pvc.Namespace, pvc.Name))
+	}
+	if len(e.PVCs) == 1 {
+		return fmt.Sprintf("PVC: %s is still in %s phase. Group snapshot will trigger after all PVCs are bound",
+			pvcs[0], e.PVCs[0].Phase)
+	}
+	return fmt.Sprintf("PVCs: %s are still in %s phase. Group snapshot will trigger after all PVCs are bound",
+		strings.Join(pvcs, ", "), v1.ClaimPending)
+}
+
+// IsPendingPVCError returns true if the error is a PendingPVCError
+func IsPendingPVCError(err error) bool {
+	_, ok := err.(*PendingPVCError)
+	return ok
+}
+
+// newPendingPVCError returns a PendingPVCError for the given PVCs
+func newPendingPVCError(pvcs []v1.PersistentVolumeClaim) *PendingPVCError {
+	pending := make([]PendingPVC, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		pending = append(pending, PendingPVC{
+			Namespace: pvc.Namespace,
+			Name:      pvc.Name,
+			Phase:     pvc.Status.Phase,
+		})
+	}
+	return &PendingPVCError{PVCs: pending}
+}
+
+// GroupSnapshotPVCOptions are the options to handle pending PVCs when getting the
+// PVCs for a group snapshot
+type GroupSnapshotPVCOptions struct {
+	// WaitForBound waits till all the PVCs are bound or the timeout elapses
+	WaitForBound bool
+	// Timeout is how long to wait for the PVCs to be bound. Defaults to
+	// defaultPVCBoundTimeout.
+	Timeout time.Duration
+	// SkipPending returns the PVCs that are still pending separately instead of
+	// failing. When waiting, this only applies to the PVCs still pending after
+	// the timeout.
+	SkipPending bool
+	// AllowEmptySelector allows empty matchLabels, selecting every PVC in the
+	// namespace. Empty matchLabels are rejected otherwise.
+	AllowEmptySelector bool
+}
+
+// GetPVCsForGroupSnapshotWithOptions returns all PVCs in given namespace that
+// match the given matchLabels, handling pending PVCs according to the options.
+// The bound PVCs are returned along with the pending PVCs that were skipped. With
+// the default options it fails if any PVC is pending like GetPVCsForGroupSnapshot.
+func GetPVCsForGroupSnapshotWithOptions(
+	namespace string,
+	matchLabels map[string]string,
+	opts GroupSnapshotPVCOptions,
+) ([]v1.PersistentVolumeClaim, []v1.PersistentVolumeClaim, error) {
+	if err := checkGroupSnapshotMatchLabels(matchLabels, opts.AllowEmptySelector); err != nil {
+		return nil, nil, err
+	}
+	matchLabels, err := NormalizeMatchLabels(matchLabels)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := EnsureNamespaceExists(namespace); err != nil {
+		return nil, nil, err
+	}
+	selector := labels.SelectorFromSet(matchLabels)
+	if !opts.WaitForBound && !opts.SkipPending {
+		pvcs, err := listPVCsForGroupSnapshot(context.Background(), namespace, selector, matchLabels)
+		return pvcs, nil, err
+	}
+
+	var bound, pending []v1.PersistentVolumeClaim
+	if opts.WaitForBound {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = defaultPVCBoundTimeout
+		}
+		interval := retryInterval
+		if interval > timeout {
+			interval = timeout
+		}
+		err = pollImmediateWithContext(context.Background(), interval, timeout, func(ctx context.Context) (bool, error) {
+			pvcs, err := listGroupSnapshotPVCs(ctx, namespace, selector, matchLabels)
+			if err != nil {
+				return false, err
+			}
+			bound, pending = splitPendingPVCs(pvcs)
+			return len(pending) == 0, nil
+		})
+		if err != nil && err != wait.ErrWaitTimeout {
+			return nil, nil, err
+		}
+	} else {
+		pvcs, err := listGroupSnapshotPVCs(context.Background(), namespace, selector, matchLabels)
+		if err != nil {
+			return nil, nil, err
+		}
+		bound, pending = splitPendingPVCs(pvcs)
+	}
+
+	if len(pending) > 0 && !opts.SkipPending {
+		return nil, nil, newPendingPVCError(pending)
+	}
+	if len(bound) == 0 {
+		return nil, pending, fmt.Errorf("found no bound PVCs for group snapshot with given label selectors: %v", matchLabels)
+	}
+	return bound, pending, nil
+}
+
+// splitPendingPVCs splits the given PVCs into the ones that are bound and the
+// ones that are still pending
+func splitPendingPVCs(pvcs []v1.PersistentVolumeClaim) ([]v1.PersistentVolumeClaim, []v1.PersistentVolumeClaim) {
+	bound := make([]v1.PersistentVolumeClaim, 0, len(pvcs))
+	pending := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == v1.ClaimPending {
+			pending = append(pending, pvc)
+		} else {
+			bound = append(bound, pvc)
+		}
+	}
+	return bound, pending
+}
+
+// NormalizeMatchLabels trims whitespace around the given label keys and values
+// and validates them against the Kubernetes label syntax. Keys that are the same
+// after trimming are rejected instead of silently dropping one of the values.
+// The returned error names the offending entry.
+func NormalizeMatchLabels(labels map[string]string) (map[string]string, error) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalized := make(map[string]string, len(labels))
+	for _, key := range keys {
+		normalizedKey := strings.TrimSpace(key)
+		value := strings.TrimSpace(labels[key])
+		if errs := validation.IsQualifiedName(normalizedKey); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid value %q for label %q: %s", labels[key], key, strings.Join(errs, "; "))
+		}
+		if _, ok := normalized[normalizedKey]; ok {
+			return nil, fmt.Errorf("label key %q is specified more than once after trimming whitespace", normalizedKey)
+		}
+		normalized[normalizedKey] = value
+	}
+	return normalized, nil
+}
+
+// GetVolumeNamesFromLabelSelector returns PV names for all PVCs in given namespace that match the given
+// labels
+func GetVolumeNamesFromLabelSelector(namespace string, labels map[string]string) ([]string, error) {
+	return GetVolumeNamesFromLabelSelectorContext(context.Background(), namespace, labels)
+}
+
+// GetVolumeNamesFromLabelSelectorContext is GetVolumeNamesFromLabelSelector with
+// a context for the client calls
+func GetVolumeNamesFromLabelSelectorContext(ctx context.Context, namespace string, labels map[string]string) ([]string, error) {
+	return GetVolumeNamesFromLabelSelectorWithWorkers(ctx, namespace, labels, defaultVolumeNameWorkers)
+}
+
+// GetVolumeNamesFromLabelSelectorWithWorkers is GetVolumeNamesFromLabelSelector
+// resolving the volume names of the PVCs with the given number of concurrent
+// workers. The names are ordered by the namespace and name of their PVCs.
+func GetVolumeNamesFromLabelSelectorWithWorkers(ctx context.Context, namespace string, labels map[string]string, workers int) ([]string, error) {
+	mapping, err := GetVolumeMappingFromLabelSelectorWithWorkers(ctx, namespace, labels, workers)
+	if err != nil {
+		return nil, err
+	}
+	return flattenVolumeMapping(mapping), nil
+}
+
+// GetVolumeNamesFromLabelSelectorAll is GetVolumeNamesFromLabelSelector
+// resolving the volume names of all the PVCs instead of stopping at the first
+// failure. If any of the PVCs couldn't be resolved, the names of the ones that
+// were are returned along with an error describing every failed PVC.
+func GetVolumeNamesFromLabelSelectorAll(namespace string, labels map[string]string) ([]string, error) {
+	ctx := context.Background()
+	pvcs, err := GetPVCsForGroupSnapshotContext(ctx, namespace, labels)
+	if err != nil {
+		return nil, err
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	getPVC := func(ctx context.Context, namespace, name string) (*v1.PersistentVolumeClaim, error) {
+		return client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	return resolveAllVolumeNames(ctx, getPVC, pvcs, defaultVolumeNameWorkers)
+}
+
+// GetVolumeMappingFromLabelSelector returns the PV name of every PVC in the given
+// namespace that matches the given labels, keyed by <namespace>/<pvc name>
+func GetVolumeMappingFromLabelSelector(namespace string, labels map[string]string) (map[string]string, error) {
+	return GetVolumeMappingFromLabelSelectorWithWorkers(context.Background(), namespace, labels, defaultVolumeNameWorkers)
+}
+
+// GetVolumeMappingFromLabelSelectorWithWorkers is GetVolumeMappingFromLabelSelector
+// resolving the volume names of the PVCs with the given number of concurrent
+// workers. An error is returned if any of the PVCs isn't bound to a volume.
+func GetVolumeMappingFromLabelSelectorWithWorkers(ctx context.Context, namespace string, labels map[string]string, workers int) (map[string]string, error) {
+	pvcs, err := GetPVCsForGroupSnapshotContext(ctx, namespace, labels)
+	if err != nil {
+		return nil, err
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	getPVC := func(ctx context.Context, namespace, name string) (*v1.PersistentVolumeClaim, error) {
+		return client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	volNames, err := resolveVolumeNames(ctx, getPVC, pvcs, workers)
+	if err != nil {
+		return nil, err
+	}
+	return getVolumeMapping(pvcs, volNames)
+}
+
+// getVolumeMapping maps the given PVCs to their resolved volume names
+func getVolumeMapping(pvcs []v1.PersistentVolumeClaim, volNames []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(pvcs))
+	for i, pvc := range pvcs {
+		if volNames[i] == "" {
+			return nil, fmt.Errorf("PVC [%s] %s is not bound to a volume", pvc.Namespace, pvc.Name)
+		}
+		mapping[pvc.Namespace+"/"+pvc.Name] = volNames[i]
+	}
+	return mapping, nil
+}
+
+// flattenVolumeMapping returns the volume names in the given mapping, ordered by
+// the namespace and name of their PVCs
+func flattenVolumeMapping(mapping map[string]string) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	volNames := make([]string, 0, len(keys))
+	for _, key := range keys {
+		volNames = append(volNames, mapping[key])
+	}
+	return volNames
+}
+
+// pvcGetFunc gets the PVC with the given namespace and name
+type pvcGetFunc func(ctx context.Context, namespace, name string) (*v1.PersistentVolumeClaim, error)
+
+// resolveVolumeNames gets the latest version of each PVC, in case it was bound
+// after it was listed, and returns their volume names in order. Resolving stops
+// after the first error. Errors from PVCs that were already being resolved are
+// also returned.
+func resolveVolumeNames(ctx context.Context, getPVC pvcGetFunc, pvcs []v1.PersistentVolumeClaim, workers int) ([]string, error) {
+	volNames := make([]string, len(pvcs))
+	err := forEachConcurrently(ctx, len(pvcs), workers, func(ctx context.Context, i int) error {
+		pvc := pvcs[i]
+		current, err := getPVC(ctx, pvc.Namespace, pvc.Name)
+		if err != nil {
+			return fmt.Errorf("error getting PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+		}
+		volNames[i] = current.Spec.VolumeName
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volNames, nil
+}
+
+// resolveAllVolumeNames is resolveVolumeNames resolving every PVC, including
+// after errors. The volume names that were resolved are returned in order along
+// with the errors for the PVCs that failed or aren't bound to a volume.
+func resolveAllVolumeNames(ctx context.Context, getPVC pvcGetFunc, pvcs []v1.PersistentVolumeClaim, workers int) ([]string, error) {
+	volNames := make([]string, len(pvcs))
+	pvcErrs := make([]error, len(pvcs))
+	// Errors are recorded per PVC rather than returned so that a failure doesn't
+	// stop the remaining PVCs from being resolved. forEachConcurrently can then
+	// only fail if the context is done before every PVC was resolved.
+	err := forEachConcurrently(ctx, len(pvcs), workers, func(ctx context.Context, i int) error {
+		pvc := pvcs[i]
+		current, err := getPVC(ctx, pvc.Namespace, pvc.Name)
+		if err != nil {
+			pvcErrs[i] = fmt.Errorf("error getting PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+		} else if current.Spec.VolumeName == "" {
+			pvcErrs[i] = fmt.Errorf("PVC [%s] %s is not bound to a volume", pvc.Namespace, pvc.Name)
+		} else {
+			volNames[i] = current.Spec.VolumeName
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pvcList.Items) == 0 {
-		return nil, fmt.Errorf("found no PVCs for group snapshot with given label selectors: %v", matchLabels)
+	resolved := make([]string, 0, len(pvcs))
+	var resolveErr *multierror.Error
+	for i, volName := range volNames {
+		if pvcErrs[i] != nil {
+			resolveErr = multierror.Append(resolveErr, pvcErrs[i])
+			continue
+		}
+		resolved = append(resolved, volName)
+	}
+	return resolved, resolveErr.ErrorOrNil()
+}
+
+// forEachConcurrently calls fn for every index from 0 to count-1 using the given
+// number of concurrent workers. No new calls are started after the first error.
+// The errors of all the calls that failed are returned, along with the error of
+// the given context if it was done before all the calls were started.
+func forEachConcurrently(ctx context.Context, count, workers int, fn func(ctx context.Context, i int) error) error {
+	if workers < 1 {
+		workers = 1
 	}
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
 
-	// Check if no PVCs are in pending state
-	for _, pvc := range pvcList.Items {
-		if pvc.Status.Phase == v1.ClaimPending {
-			return nil, fmt.Errorf("PVC: [%s] %s is still in %s phase. Group snapshot will trigger after all PVCs are bound",
-				pvc.Namespace, pvc.Name, pvc.Status.Phase)
+	indexes := make(chan int)
+	var fnErr *multierror.Error
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					lock.Lock()
+					fnErr = multierror.Append(fnErr, err)
+					lock.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	interrupted := false
+dispatch:
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			interrupted = true
+			break dispatch
 		}
 	}
+	close(indexes)
+	wg.Wait()
 
-	return pvcList.Items, nil
+	if interrupted && parentCtx.Err() != nil {
+		if fnErr == nil {
+			return parentCtx.Err()
+		}
+		fnErr = multierror.Append(fnErr, parentCtx.Err())
+	}
+	return fnErr.ErrorOrNil()
 }
 
-// GetVolumeNamesFromLabelSelector returns PV names for all PVCs in given namespace that match the given
-// labels
-func GetVolumeNamesFromLabelSelector(namespace string, labels map[string]string) ([]string, error) {
-	pvcs, err := GetPVCsForGroupSnapshot(namespace, labels)
+// GetPersistentVolumesFromLabelSelector returns the PVs bound to all PVCs in the
+// given namespace that match the given labels, in the same order as the PVCs
+func GetPersistentVolumesFromLabelSelector(namespace string, labels map[string]string) ([]*v1.PersistentVolume, error) {
+	ctx := context.Background()
+	pvcs, err := GetPVCsForGroupSnapshotContext(ctx, namespace, labels)
+	if err != nil {
+		return nil, err
+	}
+	client, err := getKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	getPVC := func(ctx context.Context, namespace, name string) (*v1.PersistentVolumeClaim, error) {
+		return client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	volNames, err := resolveVolumeNames(ctx, getPVC, pvcs, defaultVolumeNameWorkers)
 	if err != nil {
 		return nil, err
 	}
+	// Check that all the PVCs are bound before getting their PVs
+	if _, err := getVolumeMapping(pvcs, volNames); err != nil {
+		return nil, err
+	}
 
-	volNames := make([]string, 0)
-	for _, pvc := range pvcs {
-		volName, err := core.Instance().GetVolumeForPersistentVolumeClaim(&pvc)
-		if err != nil {
-			return nil, err
+	pvs := make([]*v1.PersistentVolume, len(pvcs))
+	err = forEachConcurrently(ctx, len(pvcs), defaultVolumeNameWorkers, func(ctx context.Context, i int) error {
+		pv, err := client.CoreV1().PersistentVolumes().Get(ctx, volNames[i], metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("PV %s bound to PVC [%s] %s does not exist", volNames[i], pvcs[i].Namespace, pvcs[i].Name)
+		} else if err != nil {
+			return fmt.Errorf("error getting PV %s for PVC [%s] %s: %v", volNames[i], pvcs[i].Namespace, pvcs[i].Name, err)
 		}
-
-		volNames = append(volNames, volName)
+		pvs[i] = pv
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return pvs, nil
+}
 
-	return volNames, nil
+// ErrCRDNotFound error type for CRDs that don't exist
+type ErrCRDNotFound struct {
+	// Name of the CRD
+	Name string
+}
+
+func (e *ErrCRDNotFound) Error() string {
+	return fmt.Sprintf("CRD %v not found", e.Name)
+}
+
+// CRDValidationOptions control how CRDs are validated
+type CRDValidationOptions struct {
+	// FailFastOnNotFound returns ErrCRDNotFound as soon as the CRD isn't found
+	// instead of polling until it gets created
+	FailFastOnNotFound bool
 }
 
 // ValidateCRD validate crd with apiversion v1beta1
 func ValidateCRD(client *clientset.Clientset, crdName string) error {
-	return wait.PollImmediate(retryInterval, crdTimeout, func() (bool, error) {
-		crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	return ValidateCRDWithContext(context.Background(), client, crdName)
+}
+
+// ValidateCRDWithContext validate crd with apiversion v1beta1. Polling stops when
+// the context is done. The poll options override the default timeout and interval.
+func ValidateCRDWithContext(ctx context.Context, client *clientset.Clientset, crdName string, pollOpts ...PollOption) error {
+	return ValidateCRDWithOptionsContext(ctx, client, crdName, CRDValidationOptions{}, pollOpts...)
+}
+
+// ValidateCRDWithOptions validate crd with apiversion v1beta1 using the given options
+func ValidateCRDWithOptions(client *clientset.Clientset, crdName string, opts CRDValidationOptions) error {
+	return ValidateCRDWithOptionsContext(context.Background(), client, crdName, opts)
+}
+
+// ValidateCRDWithOptionsContext validate crd with apiversion v1beta1 using the
+// given options. Polling stops when the context is done. The poll options
+// override the default timeout and interval.
+func ValidateCRDWithOptionsContext(
+	ctx context.Context,
+	client *clientset.Clientset,
+	crdName string,
+	opts CRDValidationOptions,
+	pollOpts ...PollOption,
+) error {
+	return validateCRDV1beta1(ctx, client, crdName, opts, newPollConfig(crdTimeout, retryInterval, pollOpts))
+}
+
+// ValidateCRDWithTimeout validate crd with apiversion v1beta1, checking every
+// interval till the timeout expires
+func ValidateCRDWithTimeout(client *clientset.Clientset, crdName string, timeout, interval time.Duration) error {
+	return validateCRDV1beta1(context.Background(), client, crdName, CRDValidationOptions{}, PollConfig{Timeout: timeout, Interval: interval})
+}
+
+func validateCRDV1beta1(
+	ctx context.Context,
+	client clientset.Interface,
+	crdName string,
+	opts CRDValidationOptions,
+	cfg PollConfig,
+) error {
+	found := false
+	var conditions []string
+	err := pollWithConfig(ctx, cfg, func(ctx context.Context) (bool, error) {
+		crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
 		if errors.IsNotFound(err) {
+			if opts.FailFastOnNotFound {
+				return false, &ErrCRDNotFound{Name: crdName}
+			}
 			return false, nil
 		} else if err != nil {
 			return false, err
 		}
+		found = true
+		conditions = make([]string, 0, len(crd.Status.Conditions))
 		for _, cond := range crd.Status.Conditions {
+			conditions = append(conditions, formatCRDCondition(string(cond.Type), string(cond.Status), cond.Reason))
 			switch cond.Type {
 			case apiextensionsv1beta1.Established:
 				if cond.Status == apiextensionsv1beta1.ConditionTrue {
@@ -95,18 +1046,91 @@ func ValidateCRD(client *clientset.Clientset, crdName string) error {
 		}
 		return false, nil
 	})
+	if err == wait.ErrWaitTimeout {
+		return getCRDTimeoutError(crdName, cfg.Timeout, found, conditions)
+	}
+	return err
+}
+
+// formatCRDCondition formats a CRD condition as <type>=<status> (<reason>)
+func formatCRDCondition(condType, status, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("%s=%s", condType, status)
+	}
+	return fmt.Sprintf("%s=%s (%s)", condType, status, reason)
+}
+
+// getCRDTimeoutError returns the error for a CRD that wasn't established in time,
+// with the conditions of the CRD the last time it was seen
+func getCRDTimeoutError(crdName string, timeout time.Duration, found bool, conditions []string) error {
+	if !found {
+		return fmt.Errorf("CRD %s not found after %v", crdName, timeout)
+	}
+	if len(conditions) == 0 {
+		return fmt.Errorf("timed out after %v waiting for CRD %s to be established: no conditions reported", timeout, crdName)
+	}
+	return fmt.Errorf("timed out after %v waiting for CRD %s to be established, conditions: %s",
+		timeout, crdName, strings.Join(conditions, ", "))
 }
 
 // ValidateCRDV1 validate crd with apiversion v1
 func ValidateCRDV1(client *clientset.Clientset, crdName string) error {
-	return wait.PollImmediate(retryInterval, crdTimeout, func() (bool, error) {
-		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	return ValidateCRDV1WithContext(context.Background(), client, crdName)
+}
+
+// ValidateCRDV1WithContext validate crd with apiversion v1. Polling stops when
+// the context is done. The poll options override the default timeout and interval.
+func ValidateCRDV1WithContext(ctx context.Context, client *clientset.Clientset, crdName string, pollOpts ...PollOption) error {
+	return ValidateCRDV1WithOptionsContext(ctx, client, crdName, CRDValidationOptions{}, pollOpts...)
+}
+
+// ValidateCRDV1WithOptions validate crd with apiversion v1 using the given options
+func ValidateCRDV1WithOptions(client *clientset.Clientset, crdName string, opts CRDValidationOptions) error {
+	return ValidateCRDV1WithOptionsContext(context.Background(), client, crdName, opts)
+}
+
+// ValidateCRDV1WithOptionsContext validate crd with apiversion v1 using the given
+// options. Polling stops when the context is done. The poll options override the
+// default timeout and interval.
+func ValidateCRDV1WithOptionsContext(
+	ctx context.Context,
+	client *clientset.Clientset,
+	crdName string,
+	opts CRDValidationOptions,
+	pollOpts ...PollOption,
+) error {
+	return validateCRDV1(ctx, client, crdName, opts, newPollConfig(crdTimeout, retryInterval, pollOpts))
+}
+
+// ValidateCRDV1WithTimeout validate crd with apiversion v1, checking every
+// interval till the timeout expires
+func ValidateCRDV1WithTimeout(client *clientset.Clientset, crdName string, timeout, interval time.Duration) error {
+	return validateCRDV1(context.Background(), client, crdName, CRDValidationOptions{}, PollConfig{Timeout: timeout, Interval: interval})
+}
+
+func validateCRDV1(
+	ctx context.Context,
+	client clientset.Interface,
+	crdName string,
+	opts CRDValidationOptions,
+	cfg PollConfig,
+) error {
+	found := false
+	var conditions []string
+	err := pollWithConfig(ctx, cfg, func(ctx context.Context) (bool, error) {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
 		if errors.IsNotFound(err) {
+			if opts.FailFastOnNotFound {
+				return false, &ErrCRDNotFound{Name: crdName}
+			}
 			return false, nil
 		} else if err != nil {
 			return false, err
 		}
+		found = true
+		conditions = make([]string, 0, len(crd.Status.Conditions))
 		for _, cond := range crd.Status.Conditions {
+			conditions = append(conditions, formatCRDCondition(string(cond.Type), string(cond.Status), cond.Reason))
 			switch cond.Type {
 			case apiextensionsv1.Established:
 				if cond.Status == apiextensionsv1.ConditionTrue {
@@ -120,19 +1144,344 @@ func ValidateCRDV1(client *clientset.Clientset, crdName string) error {
 		}
 		return false, nil
 	})
+	if err == wait.ErrWaitTimeout {
+		return getCRDTimeoutError(crdName, cfg.Timeout, found, conditions)
+	}
+	return err
 }
 
 // CreateCRD creates the given custom resource
 func CreateCRD(resource apiextensions.CustomResource) error {
+	crd := getCRDFromResource(resource)
+	err := Instance().Extensions().RegisterCRD(crd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateCRDDryRun submits the CRD that CreateCRD would create for the given
+// custom resource as a dry run. The CRD validated by the server is returned
+// without being persisted, so that schema or name conflicts can be caught
+// without changing the cluster.
+func CreateCRDDryRun(resource apiextensions.CustomResource) (*apiextensionsv1.CustomResourceDefinition, error) {
+	client, err := getExtensionsClient()
+	if err != nil {
+		return nil, err
+	}
+	crd := getCRDFromResource(resource)
+	return client.ApiextensionsV1().CustomResourceDefinitions().Create(context.TODO(), crd, metav1.CreateOptions{
+		DryRun: []string{metav1.DryRunAll},
+	})
+}
+
+// CreateCRDWithSchema creates the given custom resource with the given OpenAPI v3
+// schema and subresources, so that the server validates the custom resources and
+// serves the /status and /scale subresources. Unknown fields are preserved like
+// CreateCRD if no schema is given.
+func CreateCRDWithSchema(
+	resource apiextensions.CustomResource,
+	schema *apiextensionsv1.JSONSchemaProps,
+	subresources *apiextensionsv1.CustomResourceSubresources,
+) error {
+	crd := getCRDFromResourceWithSchema(resource, schema, subresources)
+	return Instance().Extensions().RegisterCRD(crd)
+}
+
+// CRDVersion is a version of a CRD registered with CreateMultiVersionCRD
+type CRDVersion struct {
+	// Name of the version, like v1alpha1
+	Name string
+	// Served is true if the version is served by the API
+	Served bool
+	// Storage is true if custom resources are persisted in this version. Exactly
+	// one version must be the storage version.
+	Storage bool
+	// Schema is the OpenAPI v3 schema for the version. Unknown fields are
+	// preserved if it is nil.
+	Schema *apiextensionsv1.JSONSchemaProps
+}
+
+// CreateMultiVersionCRD creates the given custom resource serving all the given
+// versions. The version of the custom resource itself is ignored. Custom
+// resources are not converted between versions.
+func CreateMultiVersionCRD(resource apiextensions.CustomResource, versions []CRDVersion) error {
+	crd, err := getMultiVersionCRDFromResource(resource, versions)
+	if err != nil {
+		return err
+	}
+	return Instance().Extensions().RegisterCRD(crd)
+}
+
+// getMultiVersionCRDFromResource builds the v1 CRD object for the given custom
+// resource with the given versions
+func getMultiVersionCRDFromResource(
+	resource apiextensions.CustomResource,
+	versions []CRDVersion,
+) (*apiextensionsv1.CustomResourceDefinition, error) {
+	crd := getCRDFromResource(resource)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions specified for crd %s", crd.Name)
+	}
+	storageVersions := make([]string, 0)
+	crdVersions := make([]apiextensionsv1.CustomResourceDefinitionVersion, 0, len(versions))
+	for _, version := range versions {
+		if version.Name == "" {
+			return nil, fmt.Errorf("version name is required for crd %s", crd.Name)
+		}
+		if version.Storage {
+			storageVersions = append(storageVersions, version.Name)
+		}
+		schema := version.Schema
+		if schema == nil {
+			ignoreSchemaValidation := true
+			schema = &apiextensionsv1.JSONSchemaProps{
+				XPreserveUnknownFields: &ignoreSchemaValidation,
+			}
+		}
+		crdVersions = append(crdVersions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:    version.Name,
+			Served:  version.Served,
+			Storage: version.Storage,
+			Schema: &apiextensionsv1.CustomResourceValidation{
+				OpenAPIV3Schema: schema,
+			},
+		})
+	}
+	if len(storageVersions) != 1 {
+		return nil, fmt.Errorf("exactly one storage version is required for crd %s, found %d: [%s]",
+			crd.Name, len(storageVersions), strings.Join(storageVersions, ", "))
+	}
+	crd.Spec.Versions = crdVersions
+	crd.Spec.Conversion = &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.NoneConverter,
+	}
+	return crd, nil
+}
+
+// CreateCRDWithColumns creates the given custom resource with the given printer
+// columns, which kubectl get shows in addition to the name and age
+func CreateCRDWithColumns(
+	resource apiextensions.CustomResource,
+	columns []apiextensionsv1.CustomResourceColumnDefinition,
+) error {
+	crd, err := getCRDFromResourceWithColumns(resource, columns)
+	if err != nil {
+		return err
+	}
+	return Instance().Extensions().RegisterCRD(crd)
+}
+
+// getCRDFromResourceWithColumns builds the v1 CRD object for the given custom
+// resource with the given printer columns
+func getCRDFromResourceWithColumns(
+	resource apiextensions.CustomResource,
+	columns []apiextensionsv1.CustomResourceColumnDefinition,
+) (*apiextensionsv1.CustomResourceDefinition, error) {
+	crd := getCRDFromResource(resource)
+	for _, column := range columns {
+		if column.Name == "" || column.JSONPath == "" {
+			return nil, fmt.Errorf("printer column for crd %s requires a name and jsonPath", crd.Name)
+		}
+		if !crdColumnTypes[column.Type] {
+			return nil, fmt.Errorf("unsupported type %q for printer column %s of crd %s", column.Type, column.Name, crd.Name)
+		}
+	}
+	crd.Spec.Versions[0].AdditionalPrinterColumns = columns
+	return crd, nil
+}
+
+// CreateOrUpdateCRD creates the CRD for the given custom resource, or updates the
+// existing CRD if it has already been registered. Only the versions, scope, names
+// and labels managed by stork are merged into the existing CRD, so fields added
+// out-of-band like additional printer columns are preserved. The generation of the
+// resulting CRD is returned so callers can tell whether anything changed.
+func CreateOrUpdateCRD(resource apiextensions.CustomResource) (int64, error) {
+	crd := getCRDFromResource(resource)
+	err := Instance().Extensions().RegisterCRD(crd)
+	if err == nil {
+		created, err := Instance().Extensions().GetCRD(crd.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return created.Generation, nil
+	} else if !errors.IsAlreadyExists(err) {
+		return 0, err
+	}
+
+	existing, err := Instance().Extensions().GetCRD(crd.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	mergeCRD(existing, crd)
+	updated, err := Instance().Extensions().UpdateCRD(existing)
+	if err != nil {
+		return 0, fmt.Errorf("error updating crd %s: %v", crd.Name, err)
+	}
+	return updated.Generation, nil
+}
+
+// mergeCRD merges the versions, scope, names and labels of the desired CRD into
+// the existing one. Versions with the same name are replaced, keeping their
+// printer columns and subresources if the desired version doesn't set them.
+func mergeCRD(existing, desired *apiextensionsv1.CustomResourceDefinition) {
+	for key, value := range desired.Labels {
+		if existing.Labels == nil {
+			existing.Labels = make(map[string]string)
+		}
+		existing.Labels[key] = value
+	}
+	existing.Spec.Scope = desired.Spec.Scope
+	existing.Spec.Names.Singular = desired.Spec.Names.Singular
+	existing.Spec.Names.Plural = desired.Spec.Names.Plural
+	existing.Spec.Names.Kind = desired.Spec.Names.Kind
+	existing.Spec.Names.ShortNames = desired.Spec.Names.ShortNames
+
+	hasStorage := false
+	for _, version := range desired.Spec.Versions {
+		hasStorage = hasStorage || version.Storage
+	}
+	for _, version := range desired.Spec.Versions {
+		found := false
+		for i := range existing.Spec.Versions {
+			current := &existing.Spec.Versions[i]
+			if current.Name != version.Name {
+				continue
+			}
+			if version.AdditionalPrinterColumns == nil {
+				version.AdditionalPrinterColumns = current.AdditionalPrinterColumns
+			}
+			if version.Subresources == nil {
+				version.Subresources = current.Subresources
+			}
+			*current = version
+			found = true
+			break
+		}
+		if !found {
+			existing.Spec.Versions = append(existing.Spec.Versions, version)
+		}
+	}
+	if hasStorage {
+		for i := range existing.Spec.Versions {
+			if !isDesiredStorageVersion(desired, existing.Spec.Versions[i].Name) {
+				existing.Spec.Versions[i].Storage = false
+			}
+		}
+	}
+}
+
+// isDesiredStorageVersion returns true if the given version is the storage
+// version of the CRD
+func isDesiredStorageVersion(crd *apiextensionsv1.CustomResourceDefinition, name string) bool {
+	for _, version := range crd.Spec.Versions {
+		if version.Name == name {
+			return version.Storage
+		}
+	}
+	return false
+}
+
+// ApplyCRD creates or updates the given custom resource using server-side apply
+// with fieldManager as the owner of the applied fields
+func ApplyCRD(resource apiextensions.CustomResource, fieldManager string) error {
+	if fieldManager == "" {
+		return fmt.Errorf("field manager is required to apply crd %s.%s", resource.Plural, resource.Group)
+	}
+	client, err := getExtensionsClient()
+	if err != nil {
+		return err
+	}
+	crd := getCRDFromResource(resource)
+	crd.TypeMeta = metav1.TypeMeta{
+		APIVersion: apiextensionsv1.SchemeGroupVersion.String(),
+		Kind:       "CustomResourceDefinition",
+	}
+	data, err := json.Marshal(crd)
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = client.ApiextensionsV1().CustomResourceDefinitions().Patch(
+		context.TODO(),
+		crd.Name,
+		types.ApplyPatchType,
+		data,
+		metav1.PatchOptions{FieldManager: fieldManager, Force: &force},
+	)
+	return err
+}
+
+// RegisterStorkCRDs creates or updates the CRDs for all the given custom resources
+// with CreateOrUpdateCRD and then waits for them to be established, using the v1
+// or v1beta1 apiextensions API served by the cluster. The CRDs are waited on
+// concurrently. If any of them fail, the returned error lists the CRDs that were
+// registered along with the ones that failed.
+func RegisterStorkCRDs(resources []apiextensions.CustomResource) error {
+	client, err := getExtensionsClient()
+	if err != nil {
+		return err
+	}
+
+	failed := make(map[string]error)
+	updated := make([]string, 0)
+	for _, resource := range resources {
+		crdName := fmt.Sprintf("%s.%s", resource.Plural, resource.Group)
+		if _, err := CreateOrUpdateCRD(resource); err != nil {
+			failed[crdName] = err
+			continue
+		}
+		updated = append(updated, crdName)
+	}
+	for crdName, err := range getCRDValidationFailures(client, updated, crdTimeout, retryInterval) {
+		failed[crdName] = err
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	registered := make([]string, 0)
+	for _, crdName := range updated {
+		if _, ok := failed[crdName]; !ok {
+			registered = append(registered, crdName)
+		}
+	}
+	return fmt.Errorf("failed to register CRDs: %s; registered CRDs: [%s]",
+		formatCRDFailures(failed), strings.Join(registered, ", "))
+}
+
+// getCRDFromResource builds the v1 CRD object for the given custom resource
+func getCRDFromResource(resource apiextensions.CustomResource) *apiextensionsv1.CustomResourceDefinition {
+	return getCRDFromResourceWithSchema(resource, nil, nil)
+}
+
+// getCRDFromResourceWithSchema builds the v1 CRD object for the given custom
+// resource with the given schema and subresources. Unknown fields are preserved
+// if no schema is given.
+func getCRDFromResourceWithSchema(
+	resource apiextensions.CustomResource,
+	schema *apiextensionsv1.JSONSchemaProps,
+	subresources *apiextensionsv1.CustomResourceSubresources,
+) *apiextensionsv1.CustomResourceDefinition {
 	scope := apiextensionsv1.NamespaceScoped
 	if string(resource.Scope) == string(apiextensionsv1.ClusterScoped) {
 		scope = apiextensionsv1.ClusterScoped
 	}
-	ignoreSchemaValidation := true
+	if schema == nil {
+		ignoreSchemaValidation := true
+		schema = &apiextensionsv1.JSONSchemaProps{
+			XPreserveUnknownFields: &ignoreSchemaValidation,
+		}
+	}
 	crdName := fmt.Sprintf("%s.%s", resource.Plural, resource.Group)
-	crd := &apiextensionsv1.CustomResourceDefinition{
+	var labels map[string]string
+	if storkVersion != "" {
+		labels = map[string]string{StorkVersionLabel: storkVersion}
+	}
+	return &apiextensionsv1.CustomResourceDefinition{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: crdName,
+			Name:   crdName,
+			Labels: labels,
 		},
 		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
 			Group: resource.Group,
@@ -141,10 +1490,9 @@ func CreateCRD(resource apiextensions.CustomResource) error {
 					Served:  true,
 					Storage: true,
 					Schema: &apiextensionsv1.CustomResourceValidation{
-						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
-							XPreserveUnknownFields: &ignoreSchemaValidation,
-						},
+						OpenAPIV3Schema: schema,
 					},
+					Subresources: subresources,
 				},
 			},
 			Scope: scope,
@@ -156,52 +1504,234 @@ func CreateCRD(resource apiextensions.CustomResource) error {
 			},
 		},
 	}
-	err := apiextensions.Instance().RegisterCRD(crd)
+}
+
+// GetImageRegistryFromDeployment - extract image registry and image registry secret from deployment spec.
+// The registry includes the port if there is one, and is empty for images on Docker Hub.
+// The stork container is used, falling back to the first container if there is
+// no container with that name.
+func GetImageRegistryFromDeployment(name, namespace string) (string, string, error) {
+	deploy, err := Instance().Apps().GetDeployment(name, namespace)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	return nil
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return "", "", err
+	}
+	return getImageRegistryAndSecret(deploy, container)
 }
 
-// GetImageRegistryFromDeployment - extract image registry and image registry secret from deployment spec
-func GetImageRegistryFromDeployment(name, namespace string) (string, string, error) {
-	deploy, err := apps.Instance().GetDeployment(name, namespace)
+// GetImageRegistryFromDeploymentContainer is GetImageRegistryFromDeployment for
+// the container with the given name
+func GetImageRegistryFromDeploymentContainer(name, namespace, containerName string) (string, string, error) {
+	deploy, err := Instance().Apps().GetDeployment(name, namespace)
 	if err != nil {
 		return "", "", err
 	}
-	imageFields := strings.Split(deploy.Spec.Template.Spec.Containers[0].Image, "/")
-	var registry string
-	// Here the assumtption is that the image format will be <registry-name>/<repo-name>/image:tag
-	// or <repo-name>/image:tag. If repo name contains any path (<registry-name>/<repo-name>/<extra-dir-name>/image:tag), below logic will not work.
-	if len(imageFields) == 3 {
-		registry = imageFields[0]
-	} else {
-		registry = ""
+	container, err := getDeploymentContainer(deploy, containerName)
+	if err != nil {
+		return "", "", err
+	}
+	return getImageRegistryAndSecret(deploy, container)
+}
+
+// getDeploymentContainer returns the container with the given name from the
+// deployment's pod template
+func getDeploymentContainer(deploy *appsv1.Deployment, containerName string) (*v1.Container, error) {
+	containers := deploy.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("deployment [%s] %s has no containers", deploy.Namespace, deploy.Name)
+	}
+	for i := range containers {
+		if containers[i].Name == containerName {
+			return &containers[i], nil
+		}
 	}
-	imageSecret := deploy.Spec.Template.Spec.ImagePullSecrets
-	if imageSecret != nil {
-		return registry, imageSecret[0].Name, nil
+	return nil, fmt.Errorf("container %s not found in deployment [%s] %s", containerName, deploy.Namespace, deploy.Name)
+}
+
+// getImageRegistryAndSecret returns the registry of the container's image and
+// the first image pull secret of the deployment
+func getImageRegistryAndSecret(deploy *appsv1.Deployment, container *v1.Container) (string, string, error) {
+	registry := parseImageReference(container.Image).registry
+	imageSecrets := getImagePullSecretNames(deploy)
+	if len(imageSecrets) > 0 {
+		return registry, imageSecrets[0], nil
 	}
 	return registry, "", nil
 }
 
-// GetStorkPodNamespace - will return the stork pod namespace.
+// GetImageTagFromDeployment returns the tag of the stork container's image in the
+// given deployment, or the digest if the image is pinned to one. An error is
+// returned if the image has neither, since it implicitly uses latest.
+func GetImageTagFromDeployment(name, namespace string) (string, error) {
+	deploy, err := Instance().Apps().GetDeployment(name, namespace)
+	if err != nil {
+		return "", err
+	}
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return "", err
+	}
+	return getImageTagOrDigest(container.Image)
+}
+
+// GetImagePullSecretsFromDeployment returns the names of all the image pull
+// secrets in the deployment spec, in order
+func GetImagePullSecretsFromDeployment(name, namespace string) ([]string, error) {
+	deploy, err := Instance().Apps().GetDeployment(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return getImagePullSecretNames(deploy), nil
+}
+
+// getImagePullSecretNames returns the names of the image pull secrets of the
+// deployment's pod template
+func getImagePullSecretNames(deploy *appsv1.Deployment) []string {
+	names := make([]string, 0, len(deploy.Spec.Template.Spec.ImagePullSecrets))
+	for _, secret := range deploy.Spec.Template.Spec.ImagePullSecrets {
+		names = append(names, secret.Name)
+	}
+	return names
+}
+
+// errStorkNamespaceEmpty is returned by GetStorkPodNamespaceStrict if there are
+// no stork pods
+var errStorkNamespaceEmpty = fmt.Errorf("error: stork namespace is empty")
+
+// GetStorkPodNamespace - will return the stork pod namespace. The namespace of
+// the stork pods is used if any are running, falling back to the namespace of the
+// service account when called from within the stork pod. Errors from listing the
+// pods are returned without falling back.
 func GetStorkPodNamespace() (string, error) {
-	var ns string
-	pods, err := core.Instance().ListPods(
+	ns, err := GetStorkPodNamespaceStrict()
+	if err == nil {
+		return ns, nil
+	}
+	if err != errStorkNamespaceEmpty && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if fileNs, fileErr := getNamespaceFromFile(serviceAccountNamespaceFile); fileErr == nil {
+		return fileNs, nil
+	}
+	return "", err
+}
+
+// GetStorkPodNamespaceStrict returns the namespace of the running stork pods
+// without falling back to the namespace of the service account. An error is
+// returned if there are no stork pods.
+func GetStorkPodNamespaceStrict() (string, error) {
+	pods, err := Instance().Core().ListPods(
 		map[string]string{
 			storkPodLabelKey: storkPodLabelValue,
 		},
 	)
 	if err != nil {
-		return ns, err
+		return "", err
 	}
-	if len(pods.Items) > 0 {
-		ns = pods.Items[0].Namespace
+	if len(pods.Items) == 0 || len(pods.Items[0].Namespace) == 0 {
+		return "", errStorkNamespaceEmpty
 	}
-	if len(ns) == 0 {
-		return ns, fmt.Errorf("error: stork namespace is empty")
+	return pods.Items[0].Namespace, nil
+}
+
+// GetStorkPods returns the stork pods in all namespaces. An empty list is
+// returned if there are no stork pods.
+func GetStorkPods() ([]v1.Pod, error) {
+	pods, err := Instance().Core().ListPods(
+		map[string]string{
+			storkPodLabelKey: storkPodLabelValue,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if pods.Items == nil {
+		return []v1.Pod{}, nil
+	}
+	return pods.Items, nil
+}
+
+// GetReadyStorkPodCount returns the number of stork pods that are ready
+func GetReadyStorkPodCount() (int, error) {
+	pods, err := GetStorkPods()
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+// isPodReady returns true if the pod's Ready condition is true
+func isPodReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// GetStorkPodNamespaceCached returns the stork pod namespace, looking it up with
+// GetStorkPodNamespace only till the first successful lookup. It is safe for
+// concurrent use. Concurrent calls before the first success may each look up the
+// namespace, after which all calls return the cached namespace till
+// InvalidateStorkNamespaceCache is called. Errors are not cached.
+func GetStorkPodNamespaceCached() (string, error) {
+	storkNamespaceCacheLock.RLock()
+	ns := storkNamespaceCache
+	storkNamespaceCacheLock.RUnlock()
+	if ns != "" {
+		return ns, nil
 	}
+
+	ns, err := GetStorkPodNamespace()
+	if err != nil {
+		return "", err
+	}
+	storkNamespaceCacheLock.Lock()
+	if storkNamespaceCache == "" {
+		storkNamespaceCache = ns
+	}
+	ns = storkNamespaceCache
+	storkNamespaceCacheLock.Unlock()
 	return ns, nil
+}
+
+// InvalidateStorkNamespaceCache clears the namespace cached by
+// GetStorkPodNamespaceCached so that the next call looks it up again
+func InvalidateStorkNamespaceCache() {
+	storkNamespaceCacheLock.Lock()
+	storkNamespaceCache = ""
+	storkNamespaceCacheLock.Unlock()
+}
+
+// GetStorkPodNamespaceOrDefault returns the stork pod namespace, or
+// DefaultAdminNamespace if it can't be determined
+func GetStorkPodNamespaceOrDefault() string {
+	ns, err := GetStorkPodNamespace()
+	if err != nil {
+		return DefaultAdminNamespace
+	}
+	return ns
+}
 
+// getNamespaceFromFile reads the namespace from the given file
+func getNamespaceFromFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	ns := strings.TrimSpace(string(data))
+	if len(ns) == 0 {
+		return "", fmt.Errorf("namespace file %s is empty", path)
+	}
+	return ns, nil
 }
@@ -0,0 +1,196 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestGetNamespaceFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "namespace")
+
+	_, err := getNamespaceFromFile(path)
+	require.Error(t, err, "Expected error for missing file")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("portworx\n"), 0644))
+	ns, err := getNamespaceFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "portworx", ns)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(" "), 0644))
+	_, err = getNamespaceFromFile(path)
+	require.Error(t, err, "Expected error for empty file")
+}
+
+// newPVCGetFunc returns a pvcGetFunc for the given number of PVCs, adding the
+// given latency to every get
+func newPVCGetFunc(count int, latency time.Duration) (pvcGetFunc, []v1.PersistentVolumeClaim) {
+	pvcs := make([]v1.PersistentVolumeClaim, 0, count)
+	for i := 0; i < count; i++ {
+		pvcs = append(pvcs, newPVC("ns1", fmt.Sprintf("pvc%d", i), fmt.Sprintf("pv%d", i)))
+	}
+	getPVC := func(ctx context.Context, namespace, name string) (*v1.PersistentVolumeClaim, error) {
+		time.Sleep(latency)
+		for i := range pvcs {
+			if pvcs[i].Namespace == namespace && pvcs[i].Name == name {
+				return pvcs[i].DeepCopy(), nil
+			}
+		}
+		return nil, errors.NewNotFound(v1.Resource("persistentvolumeclaims"), name)
+	}
+	return getPVC, pvcs
+}
+
+func TestResolveVolumeNames(t *testing.T) {
+	getPVC, pvcs := newPVCGetFunc(20, 0)
+	volNames, err := resolveVolumeNames(context.Background(), getPVC, pvcs, 4)
+	require.NoError(t, err)
+	require.Len(t, volNames, len(pvcs))
+	for i, volName := range volNames {
+		require.Equal(t, fmt.Sprintf("pv%d", i), volName, "Expected volume names in PVC order")
+	}
+
+	pvcs = append(pvcs, newPVC("ns1", "missing", ""))
+	_, err = resolveVolumeNames(context.Background(), getPVC, pvcs, 4)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "[ns1] missing", "Expected error to identify the PVC")
+}
+
+func TestResolveAllVolumeNames(t *testing.T) {
+	getPVC, pvcs := newPVCGetFunc(5, 0)
+	pvcs = append(pvcs, newPVC("ns1", "missing1", ""), newPVC("ns1", "missing2", ""))
+	volNames, err := resolveAllVolumeNames(context.Background(), getPVC, pvcs, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "[ns1] missing1", "Expected error to identify every failed PVC")
+	require.Contains(t, err.Error(), "[ns1] missing2", "Expected error to identify every failed PVC")
+	require.Equal(t, []string{"pv0", "pv1", "pv2", "pv3", "pv4"}, volNames, "Expected resolved volume names to be returned")
+
+	volNames, err = resolveAllVolumeNames(context.Background(), getPVC, pvcs[:5], 2)
+	require.NoError(t, err)
+	require.Len(t, volNames, 5)
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := forEachConcurrently(ctx, 10, 2, func(ctx context.Context, i int) error { return nil })
+	require.Equal(t, context.Canceled, err, "Expected the context error when dispatching is interrupted")
+
+	getPVC, pvcs := newPVCGetFunc(3, 0)
+	_, err = resolveAllVolumeNames(ctx, getPVC, pvcs, 2)
+	require.Error(t, err, "Expected resolving to fail instead of returning partial names")
+
+	started := make(chan struct{})
+	err = forEachConcurrently(context.Background(), 4, 2, func(ctx context.Context, i int) error {
+		if i == 0 {
+			<-started
+			return fmt.Errorf("first failed")
+		}
+		if i == 1 {
+			close(started)
+			<-ctx.Done()
+			return fmt.Errorf("second failed")
+		}
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "first failed")
+	require.Contains(t, err.Error(), "second failed", "Expected the errors of calls still running after the first failure")
+}
+
+func benchmarkResolveVolumeNames(b *testing.B, workers int) {
+	getPVC, pvcs := newPVCGetFunc(100, time.Millisecond)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := resolveVolumeNames(context.Background(), getPVC, pvcs, workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkResolveVolumeNamesSerial(b *testing.B) {
+	benchmarkResolveVolumeNames(b, 1)
+}
+
+func BenchmarkResolveVolumeNamesParallel(b *testing.B) {
+	benchmarkResolveVolumeNames(b, defaultVolumeNameWorkers)
+}
+
+func TestGetVolumeMapping(t *testing.T) {
+	pvcs := []v1.PersistentVolumeClaim{
+		newPVC("ns1", "pvc2", ""),
+		newPVC("ns1", "pvc1", ""),
+	}
+	mapping, err := getVolumeMapping(pvcs, []string{"pv2", "pv1"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"ns1/pvc1": "pv1", "ns1/pvc2": "pv2"}, mapping)
+	require.Equal(t, []string{"pv1", "pv2"}, flattenVolumeMapping(mapping))
+
+	_, err = getVolumeMapping(pvcs, []string{"pv2", ""})
+	require.EqualError(t, err, "PVC [ns1] pvc1 is not bound to a volume")
+}
+
+func TestGetAdminNamespace(t *testing.T) {
+	defer SetAdminNamespace("")
+
+	t.Setenv(AdminNamespaceEnvVar, "px-system")
+	require.Equal(t, "px-system", GetAdminNamespace())
+
+	SetAdminNamespace("portworx")
+	require.Equal(t, "portworx", GetAdminNamespace(), "Expected override to take precedence")
+}
+
+func TestGetContainerAdminNamespace(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: nil, expected: DefaultAdminNamespace},
+		{args: []string{"--admin-namespace=portworx"}, expected: "portworx"},
+		{args: []string{"--migration-admin-namespace=px-admin"}, expected: DefaultAdminNamespace},
+		{args: []string{"--admin-namespace=portworx", "--migration-admin-namespace=px-admin"}, expected: "portworx"},
+		{args: []string{"--admin-namespace=", "--migration-admin-namespace", "px-admin"}, expected: "px-admin"},
+		{args: []string{"--admin-namespace="}, expected: DefaultAdminNamespace},
+	}
+	for _, tc := range testCases {
+		container := &v1.Container{Args: tc.args}
+		require.Equal(t, tc.expected, getContainerAdminNamespace(container), "Unexpected admin namespace for args %v", tc.args)
+	}
+}
+
+func TestIsPodReady(t *testing.T) {
+	pod := &v1.Pod{}
+	require.False(t, isPodReady(pod), "Expected pod without conditions to not be ready")
+
+	pod.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+		{Type: v1.PodReady, Status: v1.ConditionFalse},
+	}
+	require.False(t, isPodReady(pod))
+
+	pod.Status.Conditions[1].Status = v1.ConditionTrue
+	require.True(t, isPodReady(pod))
+}
+
+func TestNormalizeMatchLabels(t *testing.T) {
+	normalized, err := NormalizeMatchLabels(map[string]string{" app ": " mysql ", "tier": "db"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app": "mysql", "tier": "db"}, normalized)
+
+	_, err = NormalizeMatchLabels(map[string]string{"app": "mysql", "app ": "postgres"})
+	require.EqualError(t, err, `label key "app" is specified more than once after trimming whitespace`)
+
+	_, err = NormalizeMatchLabels(map[string]string{"app": "my sql"})
+	require.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package k8sutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// storkMetricsPortName is the name of the port of the stork service that
+	// serves the extender, which also serves /metrics
+	storkMetricsPortName = "extender"
+	storkMetricsPort     = 8099
+	storkMetricsPath     = "/metrics"
+)
+
+// GetStorkMetric scrapes the metrics endpoint of the stork service in the given
+// namespace through the API server proxy and returns the value of the named
+// metric. If the metric has multiple series, the sum of their values is
+// returned.
+func GetStorkMetric(namespace, metricName string) (float64, error) {
+	service, err := Instance().Core().GetService(StorkServiceName, namespace)
+	if err != nil {
+		return 0, fmt.Errorf("error getting service [%s] %s: %v", namespace, StorkServiceName, err)
+	}
+	port := getStorkMetricsPort(service)
+
+	client, err := getKubernetesClient()
+	if err != nil {
+		return 0, err
+	}
+	data, err := client.CoreV1().Services(namespace).ProxyGet("http", StorkServiceName, port, storkMetricsPath, nil).DoRaw(context.TODO())
+	if err != nil {
+		return 0, fmt.Errorf("error scraping metrics from service [%s] %s: %v", namespace, StorkServiceName, err)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing metrics from service [%s] %s: %v", namespace, StorkServiceName, err)
+	}
+	family, ok := families[metricName]
+	if !ok {
+		return 0, fmt.Errorf("metric %s not found in metrics from service [%s] %s", metricName, namespace, StorkServiceName)
+	}
+
+	total := 0.0
+	for _, metric := range family.Metric {
+		value, err := getMetricValue(family.GetType(), metric)
+		if err != nil {
+			return 0, fmt.Errorf("error reading metric %s: %v", metricName, err)
+		}
+		total += value
+	}
+	return total, nil
+}
+
+// getStorkMetricsPort returns the port of the stork service that serves
+// /metrics, falling back to the default extender port
+func getStorkMetricsPort(service *v1.Service) string {
+	for _, port := range service.Spec.Ports {
+		if port.Name == storkMetricsPortName || port.Port == storkMetricsPort {
+			return strconv.Itoa(int(port.Port))
+		}
+	}
+	return strconv.Itoa(storkMetricsPort)
+}
+
+// getMetricValue returns the value of a single series of a metric
+func getMetricValue(metricType dto.MetricType, metric *dto.Metric) (float64, error) {
+	switch metricType {
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue(), nil
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue(), nil
+	case dto.MetricType_UNTYPED:
+		return metric.GetUntyped().GetValue(), nil
+	default:
+		return 0, fmt.Errorf("unsupported metric type %s", metricType.String())
+	}
+}
@@ -0,0 +1,223 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MigrationTransportNative - volumes are migrated by the volume driver
+	MigrationTransportNative = "native"
+	// MigrationTransportDataMover - volumes are migrated through an object store
+	// by the data mover
+	MigrationTransportDataMover = "datamover"
+)
+
+var (
+	// csiDriverNames maps CSI drivers to the stork volume drivers that handle them
+	csiDriverNames = map[string]string{
+		"pxd.portworx.com":      "pxd",
+		"ebs.csi.aws.com":       "aws",
+		"disk.csi.azure.com":    "azure",
+		"pd.csi.storage.gke.io": "gce",
+	}
+)
+
+// ValidateMigrationResourceSelectors checks that every kind in the include and
+// exclude lists of a migration is served by the source cluster. Kinds are
+// matched case sensitively like the migration controller does, so the returned
+// error suggests the correct spelling for kinds that only differ in case.
+func ValidateMigrationResourceSelectors(includes, excludes []string) error {
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+	kinds, err := getServedKinds(client.Discovery())
+	if err != nil {
+		return err
+	}
+
+	unknown := make([]string, 0)
+	for _, kind := range append(append([]string{}, includes...), excludes...) {
+		if kinds[kind] {
+			continue
+		}
+		suggestion := ""
+		for served := range kinds {
+			if strings.EqualFold(served, kind) {
+				suggestion = served
+				break
+			}
+		}
+		if suggestion != "" {
+			unknown = append(unknown, fmt.Sprintf("%s (did you mean %s?)", kind, suggestion))
+		} else {
+			unknown = append(unknown, kind)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown resource kinds in migration selectors: %v", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// getServedKinds returns the kinds of all the resources served by the cluster.
+// Groups that fail discovery, like aggregated APIs that are down, are skipped.
+func getServedKinds(client discovery.DiscoveryInterface) (map[string]bool, error) {
+	resourceLists, err := client.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("error discovering server resources: %v", err)
+	}
+	kinds := make(map[string]bool)
+	for _, resourceList := range resourceLists {
+		for _, resource := range resourceList.APIResources {
+			kinds[resource.Kind] = true
+		}
+	}
+	return kinds, nil
+}
+
+// CompareNamespaceResources lists the given resources in the namespace on the
+// local and remote clusters and returns the names of the objects that are
+// missing on the remote cluster and those that only exist there, keyed by
+// <resource>.<group>. The remote client is usually the Core client from
+// GetRemoteClientset for the ClusterPair used by the migration.
+func CompareNamespaceResources(
+	localClient, remoteClient kubernetes.Interface,
+	namespace string,
+	kinds []schema.GroupVersionResource,
+) (missingOnDest, extraOnDest map[string][]string, err error) {
+	missingOnDest = make(map[string][]string)
+	extraOnDest = make(map[string][]string)
+	for _, gvr := range kinds {
+		localNames, err := listResourceNames(localClient, namespace, gvr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing %v in namespace %s on local cluster: %v", gvr.GroupResource(), namespace, err)
+		}
+		remoteNames, err := listResourceNames(remoteClient, namespace, gvr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing %v in namespace %s on remote cluster: %v", gvr.GroupResource(), namespace, err)
+		}
+
+		key := gvr.GroupResource().String()
+		for name := range localNames {
+			if !remoteNames[name] {
+				missingOnDest[key] = append(missingOnDest[key], name)
+			}
+		}
+		for name := range remoteNames {
+			if !localNames[name] {
+				extraOnDest[key] = append(extraOnDest[key], name)
+			}
+		}
+		sort.Strings(missingOnDest[key])
+		sort.Strings(extraOnDest[key])
+	}
+	return missingOnDest, extraOnDest, nil
+}
+
+// listResourceNames returns the names of the objects of the given resource in
+// the namespace. The discovery REST client is used since the typed clientset
+// can't list arbitrary resources.
+func listResourceNames(client kubernetes.Interface, namespace string, gvr schema.GroupVersionResource) (map[string]bool, error) {
+	prefix := path.Join("/apis", gvr.Group, gvr.Version)
+	if gvr.Group == "" {
+		prefix = path.Join("/api", gvr.Version)
+	}
+	data, err := client.Discovery().RESTClient().Get().
+		AbsPath(prefix, "namespaces", namespace, gvr.Resource).
+		Do(context.TODO()).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{}
+	if err := list.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, item := range list.Items {
+		names[item.GetName()] = true
+	}
+	return names, nil
+}
+
+// SelectMigrationTransport returns how the volumes of the given PVCs should be
+// migrated to the remote cluster of the ClusterPair. MigrationTransportNative is
+// returned if stork uses the same driver on both clusters and the PVCs are
+// provisioned by it, and MigrationTransportDataMover otherwise. All the PVCs have
+// to use the same driver since a migration can only use one transport.
+func SelectMigrationTransport(clusterPairName, namespace string, pvcs []v1.PersistentVolumeClaim) (string, error) {
+	if len(pvcs) == 0 {
+		return "", fmt.Errorf("no PVCs given to select a migration transport for")
+	}
+	pvcDrivers := make(map[string][]string)
+	for _, pvc := range pvcs {
+		if pvc.Spec.VolumeName == "" {
+			return "", fmt.Errorf("PVC [%s] %s is not bound to a PV", pvc.Namespace, pvc.Name)
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return "", err
+		}
+		driver := getPVDriverName(pv)
+		pvcDrivers[driver] = append(pvcDrivers[driver], pvc.Name)
+	}
+	if len(pvcDrivers) > 1 {
+		groups := make([]string, 0, len(pvcDrivers))
+		for driver, names := range pvcDrivers {
+			if driver == "" {
+				driver = "unknown"
+			}
+			sort.Strings(names)
+			groups = append(groups, fmt.Sprintf("%s: %v", driver, strings.Join(names, ", ")))
+		}
+		sort.Strings(groups)
+		return "", fmt.Errorf("PVCs use different volume drivers and can't be migrated with a single transport: %v",
+			strings.Join(groups, "; "))
+	}
+
+	same, sourceDriver, _, err := CompareClusterPairDrivers(clusterPairName, namespace)
+	if err != nil {
+		return "", err
+	}
+	for driver := range pvcDrivers {
+		if same && driver == sourceDriver {
+			return MigrationTransportNative, nil
+		}
+	}
+	return MigrationTransportDataMover, nil
+}
+
+// getPVDriverName returns the name of the stork volume driver for the PV, csi for
+// CSI drivers that stork doesn't have a native driver for, or an empty string if
+// the driver isn't known
+func getPVDriverName(pv *v1.PersistentVolume) string {
+	source := pv.Spec.PersistentVolumeSource
+	switch {
+	case source.CSI != nil:
+		if driver, ok := csiDriverNames[source.CSI.Driver]; ok {
+			return driver
+		}
+		return "csi"
+	case source.PortworxVolume != nil:
+		return "pxd"
+	case source.AWSElasticBlockStore != nil:
+		return "aws"
+	case source.AzureDisk != nil:
+		return "azure"
+	case source.GCEPersistentDisk != nil:
+		return "gce"
+	}
+	return ""
+}
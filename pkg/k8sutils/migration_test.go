@@ -0,0 +1,36 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetPVDriverName(t *testing.T) {
+	newPV := func(source v1.PersistentVolumeSource) *v1.PersistentVolume {
+		return &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: source}}
+	}
+
+	require.Equal(t, "pxd", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		CSI: &v1.CSIPersistentVolumeSource{Driver: "pxd.portworx.com"},
+	})))
+	require.Equal(t, "pxd", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		PortworxVolume: &v1.PortworxVolumeSource{VolumeID: "vol1"},
+	})))
+	require.Equal(t, "aws", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		CSI: &v1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com"},
+	})))
+	require.Equal(t, "gce", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		GCEPersistentDisk: &v1.GCEPersistentDiskVolumeSource{PDName: "disk1"},
+	})))
+	require.Equal(t, "csi", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		CSI: &v1.CSIPersistentVolumeSource{Driver: "rbd.csi.ceph.com"},
+	})), "Unknown CSI drivers should use the generic csi driver")
+	require.Equal(t, "", getPVDriverName(newPV(v1.PersistentVolumeSource{
+		NFS: &v1.NFSVolumeSource{Server: "nfs", Path: "/export"},
+	})), "Non-CSI volumes without a driver should not be matched")
+}
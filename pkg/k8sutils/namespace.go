@@ -0,0 +1,153 @@
+package k8sutils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// AdminNamespaceEnvVar - environment variable to override the admin namespace
+	AdminNamespaceEnvVar = "STORK_ADMIN_NAMESPACE"
+)
+
+var (
+	// adminNamespaceOverride is the admin namespace set with SetAdminNamespace
+	adminNamespaceOverride     string
+	adminNamespaceOverrideLock sync.RWMutex
+)
+
+// SetAdminNamespace overrides the namespace returned by GetAdminNamespace. An
+// empty namespace removes the override. It is safe to call concurrently with
+// GetAdminNamespace.
+func SetAdminNamespace(namespace string) {
+	adminNamespaceOverrideLock.Lock()
+	adminNamespaceOverride = namespace
+	adminNamespaceOverrideLock.Unlock()
+}
+
+// GetAdminNamespace returns the namespace stork has been installed in. This is
+// the namespace set with SetAdminNamespace, or the one in the
+// STORK_ADMIN_NAMESPACE environment variable, or the namespace of the stork pods,
+// in that order. DefaultAdminNamespace is returned if none of them are set. This
+// is not the --admin-namespace flag of stork, which is the namespace of the
+// cluster admin for migrations and backups. Use GetStorkAdminNamespace to read
+// that flag from the deployment.
+func GetAdminNamespace() string {
+	adminNamespaceOverrideLock.RLock()
+	namespace := adminNamespaceOverride
+	adminNamespaceOverrideLock.RUnlock()
+	if namespace != "" {
+		return namespace
+	}
+	if namespace = os.Getenv(AdminNamespaceEnvVar); namespace != "" {
+		return namespace
+	}
+	if namespace, err := GetStorkPodNamespaceCached(); err == nil {
+		return namespace
+	}
+	return DefaultAdminNamespace
+}
+
+// NamespaceNotFoundError error type for namespaces that don't exist
+type NamespaceNotFoundError struct {
+	// Namespace that doesn't exist
+	Namespace string
+}
+
+func (e *NamespaceNotFoundError) Error() string {
+	return fmt.Sprintf("namespace %q does not exist", e.Namespace)
+}
+
+// IsNamespaceNotFound returns true if the error is a NamespaceNotFoundError
+func IsNamespaceNotFound(err error) bool {
+	_, ok := err.(*NamespaceNotFoundError)
+	return ok
+}
+
+// EnsureNamespaceExists returns a NamespaceNotFoundError if the given namespace
+// doesn't exist. Listing resources in a namespace that doesn't exist doesn't
+// fail, so this is used to tell a missing namespace apart from an empty one.
+func EnsureNamespaceExists(namespace string) error {
+	if _, err := Instance().Core().GetNamespace(namespace); errors.IsNotFound(err) {
+		return &NamespaceNotFoundError{Namespace: namespace}
+	} else if err != nil {
+		return fmt.Errorf("error getting namespace %s: %v", namespace, err)
+	}
+	return nil
+}
+
+// IsNamespaceStorkEnabled returns false if the given namespace has been labeled
+// or annotated to be ignored by stork
+func IsNamespaceStorkEnabled(namespace string) (bool, error) {
+	ns, err := Instance().Core().GetNamespace(namespace)
+	if err != nil {
+		return false, err
+	}
+	return !isNamespaceStorkDisabled(ns), nil
+}
+
+// isNamespaceStorkDisabled returns true if the namespace has been labeled or
+// annotated to be ignored by stork
+func isNamespaceStorkDisabled(ns *v1.Namespace) bool {
+	return isAnnotationTrue(ns.Labels, StorkDisabledNamespaceLabel) ||
+		isAnnotationTrue(ns.Annotations, StorkDisabledNamespaceLabel)
+}
+
+// GetStorkWatchedNamespaces returns the namespaces handled by the stork
+// deployment in the given namespace. Stork watches all namespaces except the
+// ones that opted out with StorkDisabledNamespaceLabel, so an empty list is
+// returned if no namespace has opted out, meaning all namespaces are watched.
+func GetStorkWatchedNamespaces(namespace string) ([]string, error) {
+	if _, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace); err != nil {
+		return nil, err
+	}
+	namespaces, err := Instance().Core().ListNamespaces(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	watched := make([]string, 0, len(namespaces.Items))
+	for i := range namespaces.Items {
+		if !isNamespaceStorkDisabled(&namespaces.Items[i]) {
+			watched = append(watched, namespaces.Items[i].Name)
+		}
+	}
+	if len(watched) == len(namespaces.Items) {
+		return []string{}, nil
+	}
+	sort.Strings(watched)
+	return watched, nil
+}
+
+// ValidateRestoreTargetNamespace returns an error if resources can't be restored
+// into the given namespace, either because it isn't in the list of allowed
+// namespaces, or because it doesn't exist or is being deleted. An empty list of
+// allowed namespaces doesn't allow any namespace.
+func ValidateRestoreTargetNamespace(namespace string, allowedNamespaces []string) error {
+	allowed := false
+	for _, ns := range allowedNamespaces {
+		if ns == namespace {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("restore to namespace %s is not allowed, allowed namespaces: %v", namespace, allowedNamespaces)
+	}
+
+	ns, err := Instance().Core().GetNamespace(namespace)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("restore target namespace %s does not exist", namespace)
+	} else if err != nil {
+		return err
+	}
+	if ns.DeletionTimestamp != nil || ns.Status.Phase == v1.NamespaceTerminating {
+		return fmt.Errorf("restore target namespace %s is terminating", namespace)
+	}
+	return nil
+}
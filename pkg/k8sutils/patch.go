@@ -0,0 +1,142 @@
+package k8sutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// defaultPatchRetries is the number of times metadata updates are retried on
+	// conflicts by the helpers in this package
+	defaultPatchRetries = 5
+)
+
+// MetadataMutateFunc changes the labels, annotations or finalizers of an object
+type MetadataMutateFunc func(obj metav1.Object)
+
+// PatchWithRetry applies the mutation to the metadata of the object and patches
+// the changed labels, annotations and finalizers. The resourceVersion is included
+// in the patch, so if the object was updated concurrently the patch fails with a
+// conflict, in which case the object is read again and the mutation re-applied,
+// up to maxRetries times. On success obj is updated to the patched object. PVCs
+// and PVs are supported.
+func PatchWithRetry(obj runtime.Object, mutate MetadataMutateFunc, maxRetries int) error {
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	var get func() (runtime.Object, error)
+	var patch func(data []byte) (runtime.Object, error)
+	var desc string
+	switch o := obj.(type) {
+	case *v1.PersistentVolumeClaim:
+		desc = fmt.Sprintf("PVC [%s] %s", o.Namespace, o.Name)
+		get = func() (runtime.Object, error) {
+			return client.CoreV1().PersistentVolumeClaims(o.Namespace).Get(context.TODO(), o.Name, metav1.GetOptions{})
+		}
+		patch = func(data []byte) (runtime.Object, error) {
+			return client.CoreV1().PersistentVolumeClaims(o.Namespace).Patch(context.TODO(), o.Name, types.MergePatchType, data, metav1.PatchOptions{})
+		}
+	case *v1.PersistentVolume:
+		desc = fmt.Sprintf("PV %s", o.Name)
+		get = func() (runtime.Object, error) {
+			return client.CoreV1().PersistentVolumes().Get(context.TODO(), o.Name, metav1.GetOptions{})
+		}
+		patch = func(data []byte) (runtime.Object, error) {
+			return client.CoreV1().PersistentVolumes().Patch(context.TODO(), o.Name, types.MergePatchType, data, metav1.PatchOptions{})
+		}
+	default:
+		return fmt.Errorf("unsupported object type %T", obj)
+	}
+
+	current := obj
+	for attempt := 0; ; attempt++ {
+		data, changed, err := getMetadataPatch(current, mutate)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return copyObject(current, obj)
+		}
+		updated, err := patch(data)
+		if err == nil {
+			return copyObject(updated, obj)
+		} else if !errors.IsConflict(err) || attempt >= maxRetries {
+			return fmt.Errorf("error patching %s: %v", desc, err)
+		}
+		if current, err = get(); err != nil {
+			return fmt.Errorf("error getting %s: %v", desc, err)
+		}
+	}
+}
+
+// getMetadataPatch returns a merge patch with the metadata fields changed by the
+// mutation and the resourceVersion of the object
+func getMetadataPatch(obj runtime.Object, mutate MetadataMutateFunc) ([]byte, bool, error) {
+	original, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, false, fmt.Errorf("object %T does not have metadata", obj)
+	}
+	modified := obj.DeepCopyObject().(metav1.Object)
+	mutate(modified)
+
+	metadata := map[string]interface{}{}
+	if labels := getMapPatch(original.GetLabels(), modified.GetLabels()); len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if annotations := getMapPatch(original.GetAnnotations(), modified.GetAnnotations()); len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if !reflect.DeepEqual(original.GetFinalizers(), modified.GetFinalizers()) {
+		finalizers := modified.GetFinalizers()
+		if finalizers == nil {
+			finalizers = []string{}
+		}
+		metadata["finalizers"] = finalizers
+	}
+	if len(metadata) == 0 {
+		return nil, false, nil
+	}
+	metadata["resourceVersion"] = original.GetResourceVersion()
+	data, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return data, true, err
+}
+
+// getMapPatch returns the merge patch for changing original into modified, with
+// nil values for the keys that were removed
+func getMapPatch(original, modified map[string]string) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key, value := range modified {
+		if current, ok := original[key]; !ok || current != value {
+			patch[key] = value
+		}
+	}
+	for key := range original {
+		if _, ok := modified[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+// copyObject copies src into dst, which must be of the same type
+func copyObject(src, dst runtime.Object) error {
+	if src == dst {
+		return nil
+	}
+	srcValue, dstValue := reflect.ValueOf(src), reflect.ValueOf(dst)
+	if srcValue.Type() != dstValue.Type() {
+		return fmt.Errorf("can't copy %T into %T", src, dst)
+	}
+	dstValue.Elem().Set(srcValue.Elem())
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetMetadataPatch(t *testing.T) {
+	pvc := newPVC("ns1", "pvc1", "pv1")
+	pvc.ResourceVersion = "10"
+	pvc.Labels = map[string]string{"app": "mysql", "tier": "db"}
+	pvc.Finalizers = []string{"kubernetes.io/pvc-protection"}
+
+	_, changed, err := getMetadataPatch(&pvc, func(obj metav1.Object) {})
+	require.NoError(t, err)
+	require.False(t, changed, "No patch expected when nothing changed")
+
+	data, changed, err := getMetadataPatch(&pvc, func(obj metav1.Object) {
+		labels := obj.GetLabels()
+		delete(labels, "tier")
+		labels["app"] = "postgres"
+		obj.SetLabels(labels)
+		obj.SetAnnotations(map[string]string{"key": "value"})
+	})
+	require.NoError(t, err)
+	require.True(t, changed, "Patch expected for changed labels and annotations")
+	patch := map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &patch))
+	require.Equal(t, map[string]interface{}{"app": "postgres", "tier": nil}, patch["metadata"]["labels"])
+	require.Equal(t, map[string]interface{}{"key": "value"}, patch["metadata"]["annotations"])
+	require.Equal(t, "10", patch["metadata"]["resourceVersion"])
+	require.NotContains(t, patch["metadata"], "finalizers")
+	require.Equal(t, "mysql", pvc.Labels["app"], "Original object should not be modified")
+
+	data, changed, err = getMetadataPatch(&pvc, func(obj metav1.Object) {
+		obj.SetFinalizers(nil)
+	})
+	require.NoError(t, err)
+	require.True(t, changed, "Patch expected for removed finalizers")
+	patch = map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &patch))
+	require.Equal(t, []interface{}{}, patch["metadata"]["finalizers"])
+}
+
+func TestCopyObject(t *testing.T) {
+	src := newPVC("ns1", "pvc1", "pv1")
+	dst := &v1.PersistentVolumeClaim{}
+	require.NoError(t, copyObject(&src, dst))
+	require.Equal(t, "pvc1", dst.Name)
+	require.Error(t, copyObject(&src, &v1.PersistentVolume{}), "Copying between types should fail")
+}
@@ -0,0 +1,154 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ValidateDestinationPlacement checks that the volumes for the given PVCs can all
+// be provisioned on the remote cluster of the ClusterPair. The StorageClass of
+// every PVC must exist on the remote cluster, any zones it is restricted to must
+// have schedulable nodes, and the storage requested by the PVCs must fit in the
+// storage quota of the namespace on the remote cluster. The returned error
+// describes every problem that was found.
+func ValidateDestinationPlacement(clusterPairName, namespace string, pvcs []v1.PersistentVolumeClaim) error {
+	remote, err := GetRemoteClientset(clusterPairName, namespace)
+	if err != nil {
+		return err
+	}
+	zones, err := getSchedulableZones(remote.Core)
+	if err != nil {
+		return fmt.Errorf("error getting nodes on remote cluster: %v", err)
+	}
+
+	problems := make([]string, 0)
+	checked := make(map[string]bool)
+	requested := make(map[string]*resource.Quantity)
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		if size, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+			if _, ok := requested[pvc.Namespace]; !ok {
+				requested[pvc.Namespace] = &resource.Quantity{}
+			}
+			requested[pvc.Namespace].Add(size)
+		}
+
+		className := getPVCStorageClassName(pvc)
+		if className == "" || checked[className] {
+			continue
+		}
+		checked[className] = true
+		class, err := remote.Core.StorageV1().StorageClasses().Get(context.TODO(), className, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			problems = append(problems, fmt.Sprintf("StorageClass %s does not exist", className))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error getting StorageClass %s on remote cluster: %v", className, err)
+		}
+		allowedZones := make([]string, 0)
+		for _, term := range class.AllowedTopologies {
+			for _, expr := range term.MatchLabelExpressions {
+				if expr.Key == zoneTopologyKey || expr.Key == zoneTopologyKeyDeprecated {
+					allowedZones = append(allowedZones, expr.Values...)
+				}
+			}
+		}
+		if len(allowedZones) > 0 && !containsAny(zones, allowedZones) {
+			problems = append(problems, fmt.Sprintf("StorageClass %s is restricted to zones %v which have no schedulable nodes",
+				className, allowedZones))
+		}
+	}
+
+	for pvcNamespace, total := range requested {
+		available, limited, err := getAvailableQuota(remote.Core, pvcNamespace, v1.ResourceRequestsStorage)
+		if err != nil {
+			return fmt.Errorf("error getting resource quotas for namespace %s on remote cluster: %v", pvcNamespace, err)
+		}
+		if limited && total.Cmp(available) > 0 {
+			problems = append(problems, fmt.Sprintf("namespace %s requests %s of storage but only %s is available in its quota",
+				pvcNamespace, total.String(), available.String()))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("volumes can't be placed on remote cluster for clusterpair (%v/%v): %v",
+			namespace, clusterPairName, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// getSchedulableZones returns the zones of the ready and schedulable nodes
+func getSchedulableZones(client kubernetes.Interface) (map[string]bool, error) {
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	zones := make(map[string]bool)
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable || !isNodeReady(&node) {
+			continue
+		}
+		if zone := node.Labels[zoneTopologyKey]; zone != "" {
+			zones[zone] = true
+		} else if zone := node.Labels[zoneTopologyKeyDeprecated]; zone != "" {
+			zones[zone] = true
+		}
+	}
+	return zones, nil
+}
+
+// isNodeReady returns true if the node has the Ready condition set
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getAvailableQuota returns the amount of the resource that can still be used
+// in the namespace according to its resource quotas. limited is false if none of
+// the quotas limit the resource.
+func getAvailableQuota(client kubernetes.Interface, namespace string, name v1.ResourceName) (resource.Quantity, bool, error) {
+	quotas, err := client.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return resource.Quantity{}, false, err
+	}
+	var available resource.Quantity
+	limited := false
+	for _, quota := range quotas.Items {
+		hard, ok := quota.Status.Hard[name]
+		if !ok {
+			continue
+		}
+		remaining := hard.DeepCopy()
+		if used, ok := quota.Status.Used[name]; ok {
+			remaining.Sub(used)
+		}
+		if !limited || remaining.Cmp(available) < 0 {
+			available = remaining
+		}
+		limited = true
+	}
+	return available, limited, nil
+}
+
+// containsAny returns true if any of the values is in the set
+func containsAny(set map[string]bool, values []string) bool {
+	for _, value := range values {
+		if set[value] {
+			return true
+		}
+	}
+	return false
+}
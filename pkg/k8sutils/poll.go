@@ -0,0 +1,155 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// pollImmediateWithContext runs the condition every interval, starting right
+// away, till it returns true or an error, the timeout expires or ctx is done.
+// The condition is passed a context that is done when polling stops so that the
+// client calls it makes are cancelled too. wait.ErrWaitTimeout is returned on a
+// timeout, and the error from ctx if it was cancelled.
+func pollImmediateWithContext(
+	ctx context.Context,
+	interval, timeout time.Duration,
+	condition func(ctx context.Context) (bool, error),
+) error {
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		return condition(pollCtx)
+	}, pollCtx.Done())
+	if err == nil {
+		return nil
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	} else if pollCtx.Err() != nil {
+		// The timeout could have expired in the middle of a client call
+		return wait.ErrWaitTimeout
+	}
+	return err
+}
+
+// validatePollInterval checks that the timeout and interval can be used for polling
+func validatePollInterval(timeout, interval time.Duration) error {
+	if interval <= 0 || timeout <= 0 {
+		return fmt.Errorf("timeout (%v) and interval (%v) must be greater than zero", timeout, interval)
+	}
+	if interval > timeout {
+		return fmt.Errorf("interval (%v) must not be greater than timeout (%v)", interval, timeout)
+	}
+	return nil
+}
+
+// PollConfig - configuration for how long and how often to poll
+type PollConfig struct {
+	// Timeout after which polling stops
+	Timeout time.Duration
+	// Interval between polls when not backing off
+	Interval time.Duration
+	// Backoff to increase the interval between polls. The fixed interval is used
+	// if it is nil.
+	Backoff *wait.Backoff
+}
+
+// PollOption - option to change the PollConfig
+type PollOption func(*PollConfig)
+
+// WithTimeout sets the timeout after which polling stops
+func WithTimeout(timeout time.Duration) PollOption {
+	return func(cfg *PollConfig) {
+		cfg.Timeout = timeout
+	}
+}
+
+// WithInterval sets a fixed interval between polls
+func WithInterval(interval time.Duration) PollOption {
+	return func(cfg *PollConfig) {
+		cfg.Interval = interval
+		cfg.Backoff = nil
+	}
+}
+
+// WithExponentialBackoff starts polling every initial interval, multiplying the
+// interval by factor after every poll till it reaches maxInterval
+func WithExponentialBackoff(initial time.Duration, factor float64, maxInterval time.Duration) PollOption {
+	return func(cfg *PollConfig) {
+		cfg.Backoff = &wait.Backoff{
+			Duration: initial,
+			Factor:   factor,
+			Steps:    math.MaxInt32,
+			Cap:      maxInterval,
+		}
+	}
+}
+
+// newPollConfig returns the PollConfig with the given defaults after applying
+// the options
+func newPollConfig(timeout, interval time.Duration, opts []PollOption) PollConfig {
+	cfg := PollConfig{
+		Timeout:  timeout,
+		Interval: interval,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// validate checks that the config can be used for polling
+func (cfg PollConfig) validate() error {
+	if cfg.Backoff == nil {
+		return validatePollInterval(cfg.Timeout, cfg.Interval)
+	}
+	if cfg.Timeout <= 0 || cfg.Backoff.Duration <= 0 {
+		return fmt.Errorf("timeout (%v) and initial backoff (%v) must be greater than zero", cfg.Timeout, cfg.Backoff.Duration)
+	}
+	if cfg.Backoff.Factor < 1 {
+		return fmt.Errorf("backoff factor (%v) must be at least 1", cfg.Backoff.Factor)
+	}
+	return nil
+}
+
+// pollWithConfig polls the condition like pollImmediateWithContext, increasing
+// the interval between polls if the config has a backoff
+func pollWithConfig(ctx context.Context, cfg PollConfig, condition func(ctx context.Context) (bool, error)) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if cfg.Backoff == nil {
+		return pollImmediateWithContext(ctx, cfg.Interval, cfg.Timeout, condition)
+	}
+
+	backoff := *cfg.Backoff
+	pollCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	for {
+		done, err := condition(pollCtx)
+		if err == nil && done {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if pollCtx.Err() != nil {
+			// The timeout could have expired in the middle of a client call
+			return wait.ErrWaitTimeout
+		} else if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-pollCtx.Done():
+			timer.Stop()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return wait.ErrWaitTimeout
+		case <-timer.C:
+		}
+	}
+}
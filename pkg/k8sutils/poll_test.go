@@ -0,0 +1,88 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestPollImmediateWithContext(t *testing.T) {
+	calls := 0
+	err := pollImmediateWithContext(context.Background(), time.Millisecond, time.Second, func(ctx context.Context) (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	err = pollImmediateWithContext(context.Background(), time.Millisecond, time.Second, func(ctx context.Context) (bool, error) {
+		return false, fmt.Errorf("condition failed")
+	})
+	require.EqualError(t, err, "condition failed")
+
+	err = pollImmediateWithContext(context.Background(), time.Millisecond, 10*time.Millisecond, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	require.Equal(t, wait.ErrWaitTimeout, err, "Expected timeout error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = pollImmediateWithContext(ctx, time.Millisecond, time.Minute, func(ctx context.Context) (bool, error) {
+		cancel()
+		return false, nil
+	})
+	require.Equal(t, context.Canceled, err, "Expected the context error when cancelled")
+}
+
+func TestValidatePollInterval(t *testing.T) {
+	require.NoError(t, validatePollInterval(time.Minute, 5*time.Second))
+	require.NoError(t, validatePollInterval(time.Second, time.Second))
+	require.Error(t, validatePollInterval(time.Second, time.Minute), "Expected error when interval is greater than timeout")
+	require.Error(t, validatePollInterval(0, time.Second), "Expected error for zero timeout")
+	require.Error(t, validatePollInterval(time.Second, 0), "Expected error for zero interval")
+}
+
+func TestNewPollConfig(t *testing.T) {
+	cfg := newPollConfig(time.Minute, 5*time.Second, nil)
+	require.Equal(t, PollConfig{Timeout: time.Minute, Interval: 5 * time.Second}, cfg)
+
+	cfg = newPollConfig(time.Minute, 5*time.Second, []PollOption{WithTimeout(time.Second), WithInterval(100 * time.Millisecond)})
+	require.Equal(t, PollConfig{Timeout: time.Second, Interval: 100 * time.Millisecond}, cfg)
+
+	cfg = newPollConfig(time.Minute, 5*time.Second, []PollOption{WithExponentialBackoff(time.Second, 2, 30*time.Second)})
+	require.NotNil(t, cfg.Backoff)
+	require.Equal(t, time.Second, cfg.Backoff.Duration)
+	require.Equal(t, 30*time.Second, cfg.Backoff.Cap)
+	require.NoError(t, cfg.validate())
+
+	cfg = newPollConfig(time.Minute, 5*time.Second, []PollOption{WithExponentialBackoff(time.Second, 0.5, 30*time.Second)})
+	require.Error(t, cfg.validate(), "Expected error for backoff factor below 1")
+}
+
+func TestPollWithConfigBackoff(t *testing.T) {
+	cfg := newPollConfig(time.Second, time.Second, []PollOption{WithExponentialBackoff(time.Millisecond, 2, 4*time.Millisecond)})
+	calls := 0
+	err := pollWithConfig(context.Background(), cfg, func(ctx context.Context) (bool, error) {
+		calls++
+		return calls == 5, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, calls)
+
+	cfg.Timeout = 20 * time.Millisecond
+	err = pollWithConfig(context.Background(), cfg, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	require.Equal(t, wait.ErrWaitTimeout, err, "Expected timeout error")
+
+	err = pollWithConfig(context.Background(), cfg, func(ctx context.Context) (bool, error) {
+		return false, fmt.Errorf("condition failed")
+	})
+	require.EqualError(t, err, "condition failed")
+}
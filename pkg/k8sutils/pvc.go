@@ -0,0 +1,513 @@
+package k8sutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// betaStorageClassAnnotation is the deprecated annotation used to set the
+// StorageClass before spec.storageClassName was added
+const betaStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+
+// GetPVCsForStatefulSet returns the bound PVCs created from the volumeClaimTemplates
+// of the given StatefulSet. PVCs are matched by the <template>-<statefulset>-<ordinal>
+// naming convention used by the StatefulSet controller.
+func GetPVCsForStatefulSet(name, namespace string) ([]v1.PersistentVolumeClaim, error) {
+	ss, err := Instance().Apps().GetStatefulSet(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(ss.Spec.VolumeClaimTemplates) == 0 {
+		return nil, fmt.Errorf("statefulset [%s] %s has no volumeClaimTemplates", namespace, name)
+	}
+
+	pvcList, err := Instance().Apps().GetPVCsForStatefulSet(ss)
+	if err != nil {
+		return nil, err
+	}
+
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcList.Items {
+		if !isStatefulSetPVC(pvc.Name, ss.Name, ss.Spec.VolumeClaimTemplates) {
+			continue
+		}
+		if pvc.Status.Phase != v1.ClaimBound {
+			continue
+		}
+		pvcs = append(pvcs, pvc)
+	}
+	return pvcs, nil
+}
+
+// isStatefulSetPVC returns true if pvcName matches <template>-<statefulset>-<ordinal>
+// for one of the given claim templates
+func isStatefulSetPVC(pvcName, ssName string, templates []v1.PersistentVolumeClaim) bool {
+	for _, template := range templates {
+		prefix := fmt.Sprintf("%s-%s-", template.Name, ssName)
+		if !strings.HasPrefix(pvcName, prefix) {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(pvcName, prefix)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPVCForPV returns the PVC that the given PV is bound to
+func GetPVCForPV(pvName string) (*v1.PersistentVolumeClaim, error) {
+	pv, err := Instance().Core().GetPersistentVolume(pvName)
+	if err != nil {
+		return nil, err
+	}
+	claimRef := pv.Spec.ClaimRef
+	if claimRef == nil {
+		return nil, fmt.Errorf("PV %s does not have a claimRef", pvName)
+	}
+
+	pvc, err := Instance().Core().GetPersistentVolumeClaim(claimRef.Name, claimRef.Namespace)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("PVC [%s] %s referenced by PV %s no longer exists", claimRef.Namespace, claimRef.Name, pvName)
+	} else if err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+// GetPVCsUsedByWorkload returns the bound PVCs referenced by the volumes of the
+// given workload. Supported kinds are Deployment, StatefulSet, DaemonSet and Pod.
+// For StatefulSets the PVCs created from volumeClaimTemplates are included too.
+func GetPVCsUsedByWorkload(kind, name, namespace string) ([]v1.PersistentVolumeClaim, error) {
+	var volumes []v1.Volume
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	switch strings.ToLower(kind) {
+	case "deployment":
+		deploy, err := Instance().Apps().GetDeployment(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		volumes = deploy.Spec.Template.Spec.Volumes
+	case "statefulset":
+		ss, err := Instance().Apps().GetStatefulSet(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		volumes = ss.Spec.Template.Spec.Volumes
+		if len(ss.Spec.VolumeClaimTemplates) > 0 {
+			templatePVCs, err := GetPVCsForStatefulSet(name, namespace)
+			if err != nil {
+				return nil, err
+			}
+			pvcs = append(pvcs, templatePVCs...)
+		}
+	case "daemonset":
+		ds, err := Instance().Apps().GetDaemonSet(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		volumes = ds.Spec.Template.Spec.Volumes
+	case "pod":
+		pod, err := Instance().Core().GetPodByName(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		volumes = pod.Spec.Volumes
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %s, supported kinds are Deployment, StatefulSet, DaemonSet and Pod", kind)
+	}
+
+	for _, volume := range volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := Instance().Core().GetPersistentVolumeClaim(volume.PersistentVolumeClaim.ClaimName, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if pvc.Status.Phase != v1.ClaimBound {
+			continue
+		}
+		pvcs = append(pvcs, *pvc)
+	}
+	return pvcs, nil
+}
+
+// GetPVCsByOwner returns the PVCs in the given namespace that have an owner
+// reference to the object with the given UID
+func GetPVCsByOwner(namespace string, ownerUID types.UID) ([]v1.PersistentVolumeClaim, error) {
+	pvcList, err := Instance().Core().GetPersistentVolumeClaims(namespace, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcList.Items {
+		for _, owner := range pvc.OwnerReferences {
+			if owner.UID == ownerUID {
+				pvcs = append(pvcs, pvc)
+				break
+			}
+		}
+	}
+	return pvcs, nil
+}
+
+// CheckClaimRefConflict returns true if the given PV has a claimRef to a PVC
+// other than the expected one. A PV without a claimRef is not a conflict since it
+// can still be bound to the expected PVC.
+func CheckClaimRefConflict(pvName, expectedPVCNamespace, expectedPVCName string) (bool, error) {
+	pv, err := Instance().Core().GetPersistentVolume(pvName)
+	if err != nil {
+		return false, err
+	}
+	claimRef := pv.Spec.ClaimRef
+	if claimRef == nil {
+		return false, nil
+	}
+	return claimRef.Namespace != expectedPVCNamespace || claimRef.Name != expectedPVCName, nil
+}
+
+// ValidatePVCCapacityMatchesPV returns an error if the capacity of the given
+// bound PVC doesn't agree with the capacity of its PV, for example when the PVC
+// has been resized but the resize hasn't completed on the PV yet
+func ValidatePVCCapacityMatchesPV(pvc *v1.PersistentVolumeClaim) error {
+	if pvc.Spec.VolumeName == "" {
+		return fmt.Errorf("PVC [%s] %s is not bound to a PV", pvc.Namespace, pvc.Name)
+	}
+	pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+	if err != nil {
+		return err
+	}
+
+	pvCapacity, ok := pv.Spec.Capacity[v1.ResourceStorage]
+	if !ok {
+		return fmt.Errorf("PV %s does not have a storage capacity", pv.Name)
+	}
+	if pvcCapacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok && pvcCapacity.Cmp(pvCapacity) != 0 {
+		return fmt.Errorf("capacity %s of PVC [%s] %s does not match capacity %s of PV %s",
+			pvcCapacity.String(), pvc.Namespace, pvc.Name, pvCapacity.String(), pv.Name)
+	}
+	if requested, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok && pvCapacity.Cmp(requested) < 0 {
+		return fmt.Errorf("capacity %s of PV %s is less than %s requested by PVC [%s] %s",
+			pvCapacity.String(), pv.Name, requested.String(), pvc.Namespace, pvc.Name)
+	}
+	return nil
+}
+
+// AnnotateMigratedPVCs sets the stork migration annotations with the source
+// cluster and namespace on the given PVCs. PVCs that already have the
+// annotations are skipped, so this is safe to call again for the same PVCs. All
+// the PVCs are processed and the errors for the ones that failed are returned
+// together.
+func AnnotateMigratedPVCs(pvcs []v1.PersistentVolumeClaim, sourceCluster, sourceNamespace string) error {
+	annotations := map[string]string{
+		StorkMigrationAnnotation:                "true",
+		StorkMigrationSourceClusterAnnotation:   sourceCluster,
+		StorkMigrationSourceNamespaceAnnotation: sourceNamespace,
+	}
+
+	var patchErr error
+	for i := range pvcs {
+		if hasAnnotations(pvcs[i].Annotations, annotations) {
+			continue
+		}
+		pvc := pvcs[i].DeepCopy()
+		err := PatchWithRetry(pvc, func(obj metav1.Object) {
+			updated := obj.GetAnnotations()
+			if updated == nil {
+				updated = make(map[string]string)
+			}
+			for key, value := range annotations {
+				updated[key] = value
+			}
+			obj.SetAnnotations(updated)
+		}, defaultPatchRetries)
+		if err != nil {
+			patchErr = multierror.Append(patchErr, fmt.Errorf("error annotating PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err))
+		}
+	}
+	return patchErr
+}
+
+// hasAnnotations returns true if all the expected annotations are set to the
+// expected values
+func hasAnnotations(annotations, expected map[string]string) bool {
+	for key, value := range expected {
+		if current, present := annotations[key]; !present || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetReclaimPoliciesForPVCs returns the reclaim policy of the PV bound to each of
+// the given PVCs, keyed by PVC name
+func GetReclaimPoliciesForPVCs(pvcs []v1.PersistentVolumeClaim) (map[string]v1.PersistentVolumeReclaimPolicy, error) {
+	policies := make(map[string]v1.PersistentVolumeReclaimPolicy)
+	for i := range pvcs {
+		pvName, err := Instance().Core().GetVolumeForPersistentVolumeClaim(&pvcs[i])
+		if err != nil {
+			return nil, err
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvName)
+		if err != nil {
+			return nil, err
+		}
+		policies[pvcs[i].Name] = pv.Spec.PersistentVolumeReclaimPolicy
+	}
+	return policies, nil
+}
+
+// FindStorageClassMismatches returns the bound PVCs whose PV is from a
+// StorageClass other than the one requested by the PVC, for example because the
+// PV was bound manually. The result is keyed by <namespace>/<name> of the PVC
+// with a description of the requested and actual StorageClass. PVCs that don't
+// request a StorageClass are skipped.
+func FindStorageClassMismatches(pvcs []v1.PersistentVolumeClaim) (map[string]string, error) {
+	mismatches := make(map[string]string)
+	for _, pvc := range pvcs {
+		requested := getPVCStorageClassName(&pvc)
+		if requested == "" || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, err
+		}
+		actual := pv.Spec.StorageClassName
+		if actual == "" {
+			actual = pv.Annotations[betaStorageClassAnnotation]
+		}
+		if actual != requested {
+			mismatches[pvc.Namespace+"/"+pvc.Name] = fmt.Sprintf("requested StorageClass %q, PV %s is from StorageClass %q",
+				requested, pv.Name, actual)
+		}
+	}
+	return mismatches, nil
+}
+
+// getPVCStorageClassName returns the StorageClass requested by the PVC
+func getPVCStorageClassName(pvc *v1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		return *pvc.Spec.StorageClassName
+	}
+	return pvc.Annotations[betaStorageClassAnnotation]
+}
+
+// EnsureStorkFinalizer adds the finalizer to the given PVC if it isn't already
+// present. Returns true if the PVC was updated.
+func EnsureStorkFinalizer(pvc *v1.PersistentVolumeClaim, finalizer string) (bool, error) {
+	if hasFinalizer(pvc, finalizer) {
+		return false, nil
+	}
+	err := PatchWithRetry(pvc, func(obj metav1.Object) {
+		if !hasFinalizer(obj, finalizer) {
+			obj.SetFinalizers(append(obj.GetFinalizers(), finalizer))
+		}
+	}, defaultPatchRetries)
+	if err != nil {
+		return false, fmt.Errorf("error updating finalizers for PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+	}
+	return true, nil
+}
+
+// RemoveStorkFinalizer removes the finalizer from the given PVC if it is present.
+// Returns true if the PVC was updated.
+func RemoveStorkFinalizer(pvc *v1.PersistentVolumeClaim, finalizer string) (bool, error) {
+	if !hasFinalizer(pvc, finalizer) {
+		return false, nil
+	}
+	err := PatchWithRetry(pvc, func(obj metav1.Object) {
+		finalizers := make([]string, 0, len(obj.GetFinalizers()))
+		for _, f := range obj.GetFinalizers() {
+			if f != finalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		obj.SetFinalizers(finalizers)
+	}, defaultPatchRetries)
+	if err != nil {
+		return false, fmt.Errorf("error updating finalizers for PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+	}
+	return true, nil
+}
+
+// hasFinalizer returns true if the object has the given finalizer
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// GetResizingPVCs returns the names of the PVCs that are being resized. A PVC is
+// being resized if it has the Resizing or FileSystemResizePending condition
+// set, or if it requests more storage than its current capacity. Snapshots taken
+// while a volume is being expanded online may not be consistent.
+func GetResizingPVCs(pvcs []v1.PersistentVolumeClaim) ([]string, error) {
+	resizing := make([]string, 0)
+	for _, pvc := range pvcs {
+		if isPVCResizing(&pvc) {
+			resizing = append(resizing, pvc.Name)
+		}
+	}
+	return resizing, nil
+}
+
+// isPVCResizing returns true if a resize is in progress for the PVC
+func isPVCResizing(pvc *v1.PersistentVolumeClaim) bool {
+	for _, cond := range pvc.Status.Conditions {
+		if (cond.Type == v1.PersistentVolumeClaimResizing || cond.Type == v1.PersistentVolumeClaimFileSystemResizePending) &&
+			cond.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	if pvc.Status.Phase != v1.ClaimBound {
+		return false
+	}
+	requested, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if !ok {
+		return false
+	}
+	capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+	return ok && capacity.Cmp(requested) < 0
+}
+
+// ClassifyPendingPVCs returns the names of the pending PVCs that are waiting for
+// a pod to be scheduled before they are provisioned, because their StorageClass
+// uses the WaitForFirstConsumer binding mode, and the names of the ones that are
+// stuck. A PVC is stuck if it uses immediate binding, or if a pod using it has
+// already been scheduled to a node. PVCs that aren't pending are ignored.
+func ClassifyPendingPVCs(pvcs []v1.PersistentVolumeClaim) ([]string, []string, error) {
+	waitingForConsumer := make([]string, 0)
+	stuck := make([]string, 0)
+	bindingModes := make(map[string]storagev1.VolumeBindingMode)
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		if pvc.Status.Phase != v1.ClaimPending {
+			continue
+		}
+
+		className := getPVCStorageClassName(pvc)
+		mode, ok := bindingModes[className]
+		if !ok && className != "" {
+			class, err := Instance().Storage().GetStorageClass(className)
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, nil, err
+			}
+			mode = storagev1.VolumeBindingImmediate
+			if err == nil && class.VolumeBindingMode != nil {
+				mode = *class.VolumeBindingMode
+			}
+			bindingModes[className] = mode
+		}
+		if mode != storagev1.VolumeBindingWaitForFirstConsumer {
+			stuck = append(stuck, pvc.Name)
+			continue
+		}
+
+		pods, err := Instance().Core().GetPodsUsingPVC(pvc.Name, pvc.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		scheduled := false
+		for _, pod := range pods {
+			if pod.Spec.NodeName != "" {
+				scheduled = true
+				break
+			}
+		}
+		if scheduled {
+			stuck = append(stuck, pvc.Name)
+		} else {
+			waitingForConsumer = append(waitingForConsumer, pvc.Name)
+		}
+	}
+	return waitingForConsumer, stuck, nil
+}
+
+// CheckRestoreAgainstQuota checks that the PVCs to be restored fit in the
+// requests.storage and persistentvolumeclaims limits of the resource quotas in
+// the given namespace, so that a restore doesn't fail after only some of the
+// PVCs have been created
+func CheckRestoreAgainstQuota(namespace string, restorePVCs []v1.PersistentVolumeClaim) error {
+	client, err := getKubernetesClient()
+	if err != nil {
+		return err
+	}
+	var requested resource.Quantity
+	for _, pvc := range restorePVCs {
+		if size, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+			requested.Add(size)
+		}
+	}
+	required := map[v1.ResourceName]resource.Quantity{
+		v1.ResourceRequestsStorage:        requested,
+		v1.ResourcePersistentVolumeClaims: *resource.NewQuantity(int64(len(restorePVCs)), resource.DecimalSI),
+	}
+
+	exceeded := make([]string, 0)
+	for _, name := range []v1.ResourceName{v1.ResourceRequestsStorage, v1.ResourcePersistentVolumeClaims} {
+		available, limited, err := getAvailableQuota(client, namespace, name)
+		if err != nil {
+			return fmt.Errorf("error getting resource quotas for namespace %s: %v", namespace, err)
+		}
+		needed := required[name]
+		if limited && needed.Cmp(available) > 0 {
+			exceeded = append(exceeded, fmt.Sprintf("%s: need %s, available %s", name, needed.String(), available.String()))
+		}
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("restore of %d PVCs would exceed resource quota in namespace %s: %v",
+			len(restorePVCs), namespace, strings.Join(exceeded, "; "))
+	}
+	return nil
+}
+
+// GetTotalCapacityForSelector returns the total storage requested by the PVCs in
+// the given namespace that match the given labels and the total capacity of the
+// volumes they are bound to. An error is returned if any of the PVCs isn't bound
+// yet. PVCs without a capacity in their status are skipped for the bound total.
+func GetTotalCapacityForSelector(namespace string, labels map[string]string) (requested, bound resource.Quantity, err error) {
+	pvcs, err := GetPVCsForGroupSnapshot(namespace, labels)
+	if err != nil {
+		return resource.Quantity{}, resource.Quantity{}, err
+	}
+	requested, bound, skipped := getTotalCapacity(pvcs)
+	if len(skipped) > 0 {
+		logrus.Warnf("PVCs %v in namespace %s have no capacity in their status, skipping them for the bound capacity", skipped, namespace)
+	}
+	return requested, bound, nil
+}
+
+// getTotalCapacity sums the requested storage and status capacity of the given
+// PVCs. The names of the PVCs without a status capacity are also returned.
+func getTotalCapacity(pvcs []v1.PersistentVolumeClaim) (resource.Quantity, resource.Quantity, []string) {
+	requested := resource.Quantity{Format: resource.BinarySI}
+	bound := resource.Quantity{Format: resource.BinarySI}
+	skipped := make([]string, 0)
+	for _, pvc := range pvcs {
+		if size, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+			requested.Add(size)
+		}
+		capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+		if !ok {
+			skipped = append(skipped, pvc.Name)
+			continue
+		}
+		bound.Add(capacity)
+	}
+	return requested, bound, skipped
+}
@@ -0,0 +1,53 @@
+//go:build unittest
+// +build unittest
+
+package k8sutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGetResizingPVCs(t *testing.T) {
+	newSizedPVC := func(name, requested, capacity string) v1.PersistentVolumeClaim {
+		pvc := newPVC("ns1", name, "pv-"+name)
+		pvc.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(requested)}
+		pvc.Status.Phase = v1.ClaimBound
+		pvc.Status.Capacity = v1.ResourceList{v1.ResourceStorage: resource.MustParse(capacity)}
+		return pvc
+	}
+
+	idle := newSizedPVC("idle", "10Gi", "10Gi")
+	expanding := newSizedPVC("expanding", "20Gi", "10Gi")
+	resizing := newSizedPVC("resizing", "10Gi", "10Gi")
+	resizing.Status.Conditions = []v1.PersistentVolumeClaimCondition{
+		{Type: v1.PersistentVolumeClaimResizing, Status: v1.ConditionTrue},
+	}
+	fsPending := newSizedPVC("fs-pending", "10Gi", "10Gi")
+	fsPending.Status.Conditions = []v1.PersistentVolumeClaimCondition{
+		{Type: v1.PersistentVolumeClaimFileSystemResizePending, Status: v1.ConditionTrue},
+	}
+	pending := newPVC("ns1", "pending", "")
+	pending.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}
+	pending.Status.Phase = v1.ClaimPending
+
+	names, err := GetResizingPVCs([]v1.PersistentVolumeClaim{idle, expanding, resizing, fsPending, pending})
+	require.NoError(t, err)
+	require.Equal(t, []string{"expanding", "resizing", "fs-pending"}, names)
+}
+
+func TestGetTotalCapacity(t *testing.T) {
+	pvc1 := newPVC("ns1", "pvc1", "pv1")
+	pvc1.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}
+	pvc1.Status.Capacity = v1.ResourceList{v1.ResourceStorage: resource.MustParse("2Gi")}
+	pvc2 := newPVC("ns1", "pvc2", "pv2")
+	pvc2.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse("3Gi")}
+
+	requested, bound, skipped := getTotalCapacity([]v1.PersistentVolumeClaim{pvc1, pvc2})
+	require.Equal(t, "4Gi", requested.String())
+	require.Equal(t, "2Gi", bound.String())
+	require.Equal(t, []string{"pvc2"}, skipped, "Expected PVC without status capacity to be skipped")
+}
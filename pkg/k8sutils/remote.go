@@ -0,0 +1,323 @@
+package k8sutils
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/portworx/sched-ops/k8s/apps"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// betaDefaultStorageClassAnnotation is the beta form of defaultStorageClassAnnotation
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+var (
+	clusterPairGVR = schema.GroupVersionResource{
+		Group:    "stork.libopenstorage.org",
+		Version:  "v1alpha1",
+		Resource: "clusterpairs",
+	}
+)
+
+// RemoteClientset holds the clients for the remote cluster of a ClusterPair
+type RemoteClientset struct {
+	// Config used to talk to the remote cluster
+	Config *rest.Config
+	// Core is the kubernetes clientset for the remote cluster
+	Core kubernetes.Interface
+	// Extensions is the apiextensions clientset for the remote cluster
+	Extensions clientset.Interface
+	// Apps is the sched-ops apps client for the remote cluster
+	Apps apps.Ops
+}
+
+// getClusterPair returns the given ClusterPair
+func getClusterPair(clusterPairName, namespace string) (*unstructured.Unstructured, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	clusterPair, err := client.Resource(clusterPairGVR).Namespace(namespace).Get(context.TODO(), clusterPairName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting clusterpair (%v/%v): %v", namespace, clusterPairName, err)
+	}
+	return clusterPair, nil
+}
+
+// getClusterPairConfig returns the kubeconfig stored in the spec of the given ClusterPair
+func getClusterPairConfig(clusterPair *unstructured.Unstructured) (*clientcmdapi.Config, error) {
+	rawConfig, found, err := unstructured.NestedMap(clusterPair.Object, "spec", "config")
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, fmt.Errorf("clusterpair (%v/%v) does not have a config", clusterPair.GetNamespace(), clusterPair.GetName())
+	}
+	data, err := json.Marshal(rawConfig)
+	if err != nil {
+		return nil, err
+	}
+	config := clientcmdapi.NewConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error decoding config for clusterpair (%v/%v): %v", clusterPair.GetNamespace(), clusterPair.GetName(), err)
+	}
+	return config, nil
+}
+
+// getClusterPairRestConfig returns the config to talk to the remote cluster of
+// the given ClusterPair
+func getClusterPairRestConfig(clusterPairName, namespace string) (*rest.Config, error) {
+	clusterPair, err := getClusterPair(clusterPairName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	config, err := getClusterPairConfig(clusterPair)
+	if err != nil {
+		return nil, err
+	}
+	remoteClientConfig := clientcmd.NewNonInteractiveClientConfig(
+		*config,
+		config.CurrentContext,
+		&clientcmd.ConfigOverrides{},
+		clientcmd.NewDefaultClientConfigLoadingRules())
+	return remoteClientConfig.ClientConfig()
+}
+
+// GetRemoteClientset returns the clients for the remote cluster of the given ClusterPair
+func GetRemoteClientset(clusterPairName, namespace string) (*RemoteClientset, error) {
+	config, err := getClusterPairRestConfig(clusterPairName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	core, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	appsClient, err := apps.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteClientset{
+		Config:     config,
+		Core:       core,
+		Extensions: extensions,
+		Apps:       appsClient,
+	}, nil
+}
+
+// ValidateRemoteCRDsExist checks that the given CRDs are registered and
+// established on the remote cluster of the ClusterPair. The returned error lists
+// all the CRDs that are missing or not established.
+func ValidateRemoteCRDsExist(clusterPairName, namespace string, crdNames []string) error {
+	remote, err := GetRemoteClientset(clusterPairName, namespace)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	notEstablished := make([]string, 0)
+	for _, crdName := range crdNames {
+		crd, err := remote.Extensions.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			missing = append(missing, crdName)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error getting CRD %s on remote cluster: %v", crdName, err)
+		}
+		if !isCRDEstablished(crd) {
+			notEstablished = append(notEstablished, crdName)
+		}
+	}
+
+	reasons := make([]string, 0)
+	if len(missing) > 0 {
+		reasons = append(reasons, fmt.Sprintf("missing: %v", strings.Join(missing, ", ")))
+	}
+	if len(notEstablished) > 0 {
+		reasons = append(reasons, fmt.Sprintf("not established: %v", strings.Join(notEstablished, ", ")))
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("CRDs not ready on remote cluster for clusterpair (%v/%v): %v",
+			namespace, clusterPairName, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// CompareClusterPairDrivers returns the volume driver used by stork on the local
+// cluster and on the remote cluster of the given ClusterPair, and whether they
+// are the same. On the remote cluster stork is looked up in the same namespace
+// as the local stork deployment, falling back to DefaultAdminNamespace.
+func CompareClusterPairDrivers(clusterPairName, namespace string) (bool, string, string, error) {
+	storkNamespace, err := GetStorkPodNamespace()
+	if err != nil {
+		return false, "", "", err
+	}
+	localDrivers, err := GetEnabledVolumeDrivers(storkNamespace)
+	if err != nil {
+		return false, "", "", err
+	}
+
+	remote, err := GetRemoteClientset(clusterPairName, namespace)
+	if err != nil {
+		return false, "", "", err
+	}
+	remoteDeploy, err := remote.Apps.GetDeployment(StorkDeploymentName, storkNamespace)
+	if errors.IsNotFound(err) && storkNamespace != DefaultAdminNamespace {
+		remoteDeploy, err = remote.Apps.GetDeployment(StorkDeploymentName, DefaultAdminNamespace)
+	}
+	if err != nil {
+		return false, "", "", fmt.Errorf("error getting stork deployment on remote cluster for clusterpair (%v/%v): %v",
+			namespace, clusterPairName, err)
+	}
+	remoteDrivers, err := getDeploymentVolumeDrivers(remoteDeploy)
+	if err != nil {
+		return false, "", "", err
+	}
+
+	sourceDriver, destDriver := localDrivers[0], remoteDrivers[0]
+	return sourceDriver == destDriver, sourceDriver, destDriver, nil
+}
+
+// ValidateClusterPairCredentialExpiry returns the time at which the credentials
+// in the kubeconfig of the given ClusterPair expire. The expiry is read from the
+// client certificate, or from the exp claim for token auth with a JWT. The zero
+// time is returned if the credentials don't expire, like static tokens, and an
+// error is returned if they have already expired.
+func ValidateClusterPairCredentialExpiry(clusterPairName, namespace string) (time.Time, error) {
+	clusterPair, err := getClusterPair(clusterPairName, namespace)
+	if err != nil {
+		return time.Time{}, err
+	}
+	config, err := getClusterPairConfig(clusterPair)
+	if err != nil {
+		return time.Time{}, err
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return time.Time{}, fmt.Errorf("context %v not found in config for clusterpair (%v/%v)", config.CurrentContext, namespace, clusterPairName)
+	}
+	authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return time.Time{}, fmt.Errorf("user %v not found in config for clusterpair (%v/%v)", kubeContext.AuthInfo, namespace, clusterPairName)
+	}
+
+	var expiry time.Time
+	certData := authInfo.ClientCertificateData
+	if len(certData) == 0 && authInfo.ClientCertificate != "" {
+		if certData, err = ioutil.ReadFile(authInfo.ClientCertificate); err != nil {
+			return time.Time{}, fmt.Errorf("error reading client certificate for clusterpair (%v/%v): %v", namespace, clusterPairName, err)
+		}
+	}
+	if len(certData) > 0 {
+		block, _ := pem.Decode(certData)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("client certificate for clusterpair (%v/%v) is not PEM encoded", namespace, clusterPairName)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing client certificate for clusterpair (%v/%v): %v", namespace, clusterPairName, err)
+		}
+		expiry = cert.NotAfter
+	} else if authInfo.Token != "" {
+		expiry = getTokenExpiry(authInfo.Token)
+	}
+
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		return expiry, fmt.Errorf("credentials for clusterpair (%v/%v) expired at %v", namespace, clusterPairName, expiry)
+	}
+	return expiry, nil
+}
+
+// getTokenExpiry returns the time from the exp claim of a JWT, or the zero time
+// if the token isn't a JWT or doesn't expire
+func getTokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// EnsureStorageClassOnRemote creates the StorageClass with the given name on the
+// remote cluster of the ClusterPair, copying it from the local cluster. If the
+// name is in remap the class is created with the mapped name instead. Nothing is
+// done if the class already exists on the remote cluster. The copy is never
+// marked as the default class so it doesn't conflict with the remote default.
+func EnsureStorageClassOnRemote(clusterPairName, namespace, storageClassName string, remap map[string]string) error {
+	remote, err := GetRemoteClientset(clusterPairName, namespace)
+	if err != nil {
+		return err
+	}
+	destName := storageClassName
+	if mapped, ok := remap[storageClassName]; ok && mapped != "" {
+		destName = mapped
+	}
+	_, err = remote.Core.StorageV1().StorageClasses().Get(context.TODO(), destName, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("error getting StorageClass %s on remote cluster: %v", destName, err)
+	}
+
+	source, err := Instance().Storage().GetStorageClass(storageClassName)
+	if err != nil {
+		return err
+	}
+	annotations := make(map[string]string)
+	for key, value := range source.Annotations {
+		if key != defaultStorageClassAnnotation && key != betaDefaultStorageClassAnnotation {
+			annotations[key] = value
+		}
+	}
+	class := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        destName,
+			Labels:      source.Labels,
+			Annotations: annotations,
+		},
+		Provisioner:          source.Provisioner,
+		Parameters:           source.Parameters,
+		ReclaimPolicy:        source.ReclaimPolicy,
+		MountOptions:         source.MountOptions,
+		AllowVolumeExpansion: source.AllowVolumeExpansion,
+		VolumeBindingMode:    source.VolumeBindingMode,
+		AllowedTopologies:    source.AllowedTopologies,
+	}
+	_, err = remote.Core.StorageV1().StorageClasses().Create(context.TODO(), class, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating StorageClass %s on remote cluster: %v", destName, err)
+	}
+	return nil
+}
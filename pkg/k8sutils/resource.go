@@ -0,0 +1,67 @@
+package k8sutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// storkFailedPhases are the terminal values of the status fields of stork CRs
+// that indicate the operation failed
+var storkFailedPhases = []string{"Failed"}
+
+// WaitForStorkResourcePhase waits till the field at phaseJSONPath of the given
+// stork CR is set to targetPhase, for example {.status.status} of a Migration.
+// An error is returned right away if the field is set to a failed phase instead.
+func WaitForStorkResourcePhase(
+	gvr schema.GroupVersionResource,
+	namespace, name, phaseJSONPath, targetPhase string,
+	timeout time.Duration,
+) error {
+	if !strings.HasPrefix(phaseJSONPath, "{") {
+		phaseJSONPath = "{" + phaseJSONPath + "}"
+	}
+	parser := jsonpath.New("phase")
+	if err := parser.Parse(phaseJSONPath); err != nil {
+		return fmt.Errorf("invalid jsonpath %s: %v", phaseJSONPath, err)
+	}
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	phase := ""
+	err = wait.PollImmediate(retryInterval, timeout, func() (bool, error) {
+		obj, err := client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		var buf bytes.Buffer
+		if err := parser.Execute(&buf, obj.Object); err != nil {
+			// The status might not have been set yet
+			return false, nil
+		}
+		phase = buf.String()
+		if phase == targetPhase {
+			return true, nil
+		}
+		for _, failed := range storkFailedPhases {
+			if phase == failed {
+				return false, fmt.Errorf("%s [%s] %s is in %s phase", gvr.Resource, namespace, name, phase)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out waiting for %s [%s] %s to reach %s phase, current phase: %q",
+			gvr.Resource, namespace, name, targetPhase, phase)
+	}
+	return err
+}
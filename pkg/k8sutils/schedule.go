@@ -0,0 +1,150 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DefaultMinSnapshotInterval - minimum interval between scheduled snapshots
+	// for drivers that don't have their own minimum
+	DefaultMinSnapshotInterval = 5 * time.Minute
+)
+
+var (
+	volumeSnapshotScheduleGVR = schema.GroupVersionResource{
+		Group:    "stork.libopenstorage.org",
+		Version:  "v1alpha1",
+		Resource: "volumesnapshotschedules",
+	}
+)
+
+var (
+	// minSnapshotIntervals are the default minimum intervals between scheduled
+	// snapshots for each driver. Local snapshots on portworx are cheap, while
+	// cloud drivers are rate limited by the provider and kdmp copies the data
+	// for every snapshot.
+	minSnapshotIntervals = map[string]time.Duration{
+		"pxd":   1 * time.Minute,
+		"csi":   5 * time.Minute,
+		"aws":   15 * time.Minute,
+		"azure": 15 * time.Minute,
+		"gce":   15 * time.Minute,
+		"kdmp":  15 * time.Minute,
+	}
+	minSnapshotIntervalsLock sync.RWMutex
+)
+
+// SetMinSnapshotInterval overrides the minimum interval between scheduled
+// snapshots for the given driver
+func SetMinSnapshotInterval(driverName string, interval time.Duration) {
+	minSnapshotIntervalsLock.Lock()
+	defer minSnapshotIntervalsLock.Unlock()
+	minSnapshotIntervals[driverName] = interval
+}
+
+// GetMinSnapshotInterval returns the minimum interval between scheduled
+// snapshots for the given driver, or DefaultMinSnapshotInterval if the driver
+// doesn't have one
+func GetMinSnapshotInterval(driverName string) time.Duration {
+	minSnapshotIntervalsLock.RLock()
+	defer minSnapshotIntervalsLock.RUnlock()
+	if interval, ok := minSnapshotIntervals[driverName]; ok {
+		return interval
+	}
+	return DefaultMinSnapshotInterval
+}
+
+// ValidateSnapshotSchedule returns an error if snapshots for the given driver
+// would be scheduled more often than the minimum interval for the driver
+func ValidateSnapshotSchedule(driverName string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid snapshot interval %v, interval should be greater than 0", interval)
+	}
+	if minInterval := GetMinSnapshotInterval(driverName); interval < minInterval {
+		return fmt.Errorf("snapshot interval %v is less than the minimum interval %v for driver %s", interval, minInterval, driverName)
+	}
+	return nil
+}
+
+// ListPVCsMissingRecentSnapshot returns the PVCs in the given namespace that have
+// a VolumeSnapshotSchedule but whose latest successful scheduled snapshot
+// finished more than maxAge ago, or that have never been snapshotted
+func ListPVCsMissingRecentSnapshot(namespace string, maxAge time.Duration) ([]v1.PersistentVolumeClaim, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := client.Resource(volumeSnapshotScheduleGVR).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumesnapshotschedules in namespace %s: %v", namespace, err)
+	}
+
+	// Multiple schedules can protect the same PVC, so track the newest
+	// snapshot across all of them
+	latest := make(map[string]time.Time)
+	for _, schedule := range schedules.Items {
+		pvcName, _, _ := unstructured.NestedString(schedule.Object, "spec", "template", "spec", "persistentVolumeClaimName")
+		if pvcName == "" {
+			continue
+		}
+		finished := getLatestScheduledSnapshotTime(schedule)
+		if current, ok := latest[pvcName]; !ok || finished.After(current) {
+			latest[pvcName] = finished
+		}
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	missing := make([]v1.PersistentVolumeClaim, 0)
+	for pvcName, finished := range latest {
+		if finished.After(cutoff) {
+			continue
+		}
+		pvc, err := Instance().Core().GetPersistentVolumeClaim(pvcName, namespace)
+		if errors.IsNotFound(err) {
+			// Schedules for deleted PVCs don't leave anything unprotected
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		missing = append(missing, *pvc)
+	}
+	return missing, nil
+}
+
+// getLatestScheduledSnapshotTime returns the finish time of the newest
+// successful snapshot triggered by the VolumeSnapshotSchedule, or the zero time
+// if there isn't one
+func getLatestScheduledSnapshotTime(schedule unstructured.Unstructured) time.Time {
+	var latest time.Time
+	items, _, _ := unstructured.NestedMap(schedule.Object, "status", "items")
+	for _, policyItems := range items {
+		snapshots, ok := policyItems.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range snapshots {
+			snapshot, ok := item.(map[string]interface{})
+			if !ok || snapshot["status"] != "Ready" {
+				continue
+			}
+			timestamp, ok := snapshot["finishTimestamp"].(string)
+			if !ok {
+				continue
+			}
+			finished, err := time.Parse(time.RFC3339, timestamp)
+			if err == nil && finished.After(latest) {
+				latest = finished
+			}
+		}
+	}
+	return latest
+}
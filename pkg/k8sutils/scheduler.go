@@ -0,0 +1,122 @@
+package k8sutils
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// StorkServiceName - name of the service fronting the stork extender and webhook
+	StorkServiceName = "stork-service"
+
+	schedulerConfigNamespace = "kube-system"
+)
+
+// schedulerPolicyConfigMaps are the names of the configmaps that the scheduler
+// reads its policy or KubeSchedulerConfiguration from. stork-config is the one
+// shipped with the stork specs, the others are used by common distributions.
+var schedulerPolicyConfigMaps = []string{
+	"stork-config",
+	"scheduler-policy",
+	"kube-scheduler",
+	"scheduler-config",
+}
+
+// GetSchedulerPolicyConfigMap returns the configmap in kube-system that the
+// scheduler reads its policy or KubeSchedulerConfiguration from
+func GetSchedulerPolicyConfigMap() (*v1.ConfigMap, error) {
+	configMaps, err := getSchedulerPolicyConfigMaps(schedulerConfigNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(configMaps) == 0 {
+		return nil, fmt.Errorf("no scheduler config found in namespace %s, looked for configmaps %v",
+			schedulerConfigNamespace, schedulerPolicyConfigMaps)
+	}
+	return configMaps[0], nil
+}
+
+// getSchedulerPolicyConfigMaps returns the scheduler policy configmaps that
+// exist in the given namespace, in the order of schedulerPolicyConfigMaps
+func getSchedulerPolicyConfigMaps(namespace string) ([]*v1.ConfigMap, error) {
+	configMaps := make([]*v1.ConfigMap, 0)
+	for _, name := range schedulerPolicyConfigMaps {
+		cm, err := Instance().Core().GetConfigMap(name, namespace)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		configMaps = append(configMaps, cm)
+	}
+	return configMaps, nil
+}
+
+// CheckSchedulerExtenderConfigured checks if the scheduler configuration in the
+// given namespace references the stork extender service. If it doesn't, false is
+// returned along with an error describing why.
+func CheckSchedulerExtenderConfigured(kubeSystemNamespace string) (bool, error) {
+	configMaps, err := getSchedulerPolicyConfigMaps(kubeSystemNamespace)
+	if err != nil {
+		return false, err
+	}
+	if len(configMaps) == 0 {
+		return false, fmt.Errorf("no scheduler config found in namespace %s, looked for configmaps %v",
+			kubeSystemNamespace, schedulerPolicyConfigMaps)
+	}
+
+	searched := make([]string, 0)
+	for _, cm := range configMaps {
+		for _, data := range cm.Data {
+			if strings.Contains(data, StorkServiceName) {
+				return true, nil
+			}
+		}
+		searched = append(searched, cm.Name)
+	}
+	return false, fmt.Errorf("scheduler config in namespace %s (configmaps %v) does not reference the %s extender",
+		kubeSystemNamespace, searched, StorkServiceName)
+}
+
+// GetExtenderServingPods returns the stork pods in the given namespace that are
+// ready endpoints of the stork service, and hence can serve extender requests
+func GetExtenderServingPods(namespace string) ([]v1.Pod, error) {
+	endpoints, err := Instance().Core().GetEndpoints(StorkServiceName, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error getting endpoints for service [%s] %s: %v", namespace, StorkServiceName, err)
+	}
+
+	pods := make([]v1.Pod, 0)
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			pod, err := Instance().Core().GetPodByName(address.TargetRef.Name, address.TargetRef.Namespace)
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, nil
+}
+
+// GetExtenderServingPod returns a stork pod that is currently a ready endpoint of
+// the stork service. If multiple replicas are ready the first one listed in the
+// service endpoints is returned, use GetExtenderServingPods to get all of them.
+func GetExtenderServingPod(namespace string) (*v1.Pod, error) {
+	pods, err := GetExtenderServingPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no ready endpoints for service [%s] %s", namespace, StorkServiceName)
+	}
+	return &pods[0], nil
+}
@@ -0,0 +1,27 @@
+package k8sutils
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// EnsurePullSecretOnServiceAccount adds the given image pull secret to the
+// service account in the namespace if it isn't already present
+func EnsurePullSecretOnServiceAccount(namespace, serviceAccount, secretName string) error {
+	sa, err := Instance().Core().GetServiceAccount(serviceAccount, namespace)
+	if err != nil {
+		return fmt.Errorf("error getting service account [%s] %s: %v", namespace, serviceAccount, err)
+	}
+	for _, secret := range sa.ImagePullSecrets {
+		if secret.Name == secretName {
+			return nil
+		}
+	}
+
+	sa.ImagePullSecrets = append(sa.ImagePullSecrets, v1.LocalObjectReference{Name: secretName})
+	if _, err := Instance().Core().UpdateServiceAccount(sa); err != nil {
+		return fmt.Errorf("error adding image pull secret %s to service account [%s] %s: %v", secretName, namespace, serviceAccount, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// StorkVersionLabel - label set on objects created by stork with the version
+	// of stork that created them
+	StorkVersionLabel = StorkAnnotationPrefix + "version"
+	// StorkVersionConfigMapName - name of the configmap in the admin namespace
+	// that the running stork version is written to on startup
+	StorkVersionConfigMapName = "stork-version"
+	storkVersionConfigMapKey  = "version"
+
+	// StorkComponentDeployment - version of the image in the stork deployment
+	StorkComponentDeployment = "deployment"
+	// StorkComponentRunning - version reported by the running stork binary
+	StorkComponentRunning = "running"
+	// StorkComponentCRDs - version of stork that registered the stork CRDs
+	StorkComponentCRDs = "crds"
+	// StorkComponentWebhook - version of stork that created the webhook configuration
+	StorkComponentWebhook = "webhook"
+)
+
+// storkVersion is the version of the running stork binary, set on the CRDs
+// registered by stork
+var storkVersion string
+
+// SetStorkVersion sets the version of the running stork binary. CRDs registered
+// after this are labeled with StorkVersionLabel.
+func SetStorkVersion(version string) {
+	storkVersion = version
+}
+
+// ErrStorkComponentSkew error type for stork components running different versions
+type ErrStorkComponentSkew struct {
+	// Versions of each component
+	Versions map[string]string
+}
+
+func (e *ErrStorkComponentSkew) Error() string {
+	components := make([]string, 0, len(e.Versions))
+	for component, version := range e.Versions {
+		components = append(components, fmt.Sprintf("%s=%s", component, version))
+	}
+	sort.Strings(components)
+	return fmt.Sprintf("stork components are at different versions: %s", strings.Join(components, ", "))
+}
+
+// DetectStorkComponentSkew returns the versions of the stork deployment in the
+// given namespace, the running stork binary, the stork CRDs and the stork webhook
+// configuration, keyed by StorkComponent*. The versions of the CRDs and webhook
+// configuration are read from StorkVersionLabel, and components that don't have
+// a version recorded are left out. An ErrStorkComponentSkew is returned along
+// with the versions if they don't all match.
+func DetectStorkComponentSkew(namespace string) (map[string]string, error) {
+	versions := make(map[string]string)
+
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return nil, err
+	}
+	if tag := getImageTag(container.Image); tag != "" {
+		versions[StorkComponentDeployment] = tag
+	}
+
+	cm, err := Instance().Core().GetConfigMap(StorkVersionConfigMapName, GetAdminNamespace())
+	if err == nil {
+		if version := cm.Data[storkVersionConfigMapKey]; version != "" {
+			versions[StorkComponentRunning] = version
+		}
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	crdVersion, err := getStorkCRDsVersion()
+	if err != nil {
+		return nil, err
+	}
+	if crdVersion != "" {
+		versions[StorkComponentCRDs] = crdVersion
+	}
+
+	webhookCfg, err := Instance().AdmissionRegistration().GetMutatingWebhookConfiguration(StorkWebhookConfigName)
+	if err == nil {
+		if version := webhookCfg.Labels[StorkVersionLabel]; version != "" {
+			versions[StorkComponentWebhook] = version
+		}
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	normalized := ""
+	for _, version := range versions {
+		if normalized == "" {
+			normalized = normalizeStorkVersion(version)
+		} else if normalizeStorkVersion(version) != normalized {
+			return versions, &ErrStorkComponentSkew{Versions: versions}
+		}
+	}
+	return versions, nil
+}
+
+// GetStorkVersionFromDeployment returns the version of stork in the given
+// namespace from the tag of the image in the stork deployment
+func GetStorkVersionFromDeployment(namespace string) (string, error) {
+	deploy, err := Instance().Apps().GetDeployment(StorkDeploymentName, namespace)
+	if err != nil {
+		return "", err
+	}
+	container, err := getStorkContainer(deploy)
+	if err != nil {
+		return "", err
+	}
+	tag := getImageTag(container.Image)
+	if tag == "" {
+		return "", fmt.Errorf("image %s for deployment [%s] %s does not have a tag", container.Image, namespace, StorkDeploymentName)
+	}
+	return tag, nil
+}
+
+// getStorkCRDsVersion returns the StorkVersionLabel set on the stork CRDs. If
+// the CRDs have been registered by different versions they are all returned,
+// comma separated.
+func getStorkCRDsVersion() (string, error) {
+	client, err := getExtensionsClient()
+	if err != nil {
+		return "", err
+	}
+	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	versions := make(map[string]bool)
+	for _, crd := range crds.Items {
+		if !isStorkCRDGroup(crd.Spec.Group) {
+			continue
+		}
+		if version := crd.Labels[StorkVersionLabel]; version != "" {
+			versions[version] = true
+		}
+	}
+	list := make([]string, 0, len(versions))
+	for version := range versions {
+		list = append(list, version)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ","), nil
+}
+
+// getImageTag returns the tag of the given image, or an empty string if it is
+// referenced without a tag
+func getImageTag(image string) string {
+	return parseImageReference(image).tag
+}
+
+// normalizeStorkVersion strips the v prefix and any pre-release or build suffix
+// so that an image tag like 2.8.0 matches a binary version like 2.8.0-1a2b3c
+func normalizeStorkVersion(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	return strings.SplitN(version, "-", 2)[0]
+}
@@ -0,0 +1,353 @@
+package k8sutils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	snapshotGroup                = "snapshot.storage.k8s.io"
+	volumeSnapshotClassCRDName   = "volumesnapshotclasses." + snapshotGroup
+	volumeSnapshotContentCRDName = "volumesnapshotcontents." + snapshotGroup
+	volumeSnapshotCRDName        = "volumesnapshots." + snapshotGroup
+)
+
+var (
+	// volumeSnapshotGVRs are the CSI VolumeSnapshot APIs in order of preference
+	volumeSnapshotGVRs = []schema.GroupVersionResource{
+		{Group: snapshotGroup, Version: "v1", Resource: "volumesnapshots"},
+		{Group: snapshotGroup, Version: "v1beta1", Resource: "volumesnapshots"},
+	}
+)
+
+var (
+	// snapshotControllerSelectors are the labels used for the external snapshot
+	// controller deployment by the upstream manifests, the helm charts and
+	// OpenShift
+	snapshotControllerSelectors = []string{
+		"app=snapshot-controller",
+		"app.kubernetes.io/name=snapshot-controller",
+		"app=csi-snapshot-controller",
+	}
+)
+
+var (
+	// snapshotVolumeModes are the volume modes that each driver can snapshot.
+	// Drivers that aren't listed only support Filesystem volumes. kdmp copies the
+	// files from the volume so it can't snapshot raw block volumes.
+	snapshotVolumeModes = map[string][]v1.PersistentVolumeMode{
+		"pxd":   {v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock},
+		"csi":   {v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock},
+		"aws":   {v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock},
+		"azure": {v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock},
+		"gce":   {v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock},
+		"kdmp":  {v1.PersistentVolumeFilesystem},
+	}
+)
+
+// listVolumeSnapshots lists CSI VolumeSnapshots in the given namespace using the
+// first snapshot API version served by the cluster
+func listVolumeSnapshots(namespace string, opts metav1.ListOptions) ([]unstructured.Unstructured, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, gvr := range volumeSnapshotGVRs {
+		snapList, err := client.Resource(gvr).Namespace(namespace).List(context.TODO(), opts)
+		if errors.IsNotFound(err) {
+			// This version of the API isn't served, try the next one
+			lastErr = err
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return snapList.Items, nil
+	}
+	return nil, lastErr
+}
+
+// getVolumeSnapshot returns the CSI VolumeSnapshot with the given name using the
+// first snapshot API version served by the cluster
+func getVolumeSnapshot(namespace, name string) (*unstructured.Unstructured, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, gvr := range volumeSnapshotGVRs {
+		snapshot, err := client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			// Either the snapshot or this version of the API doesn't exist, try the next one
+			lastErr = err
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return snapshot, nil
+	}
+	return nil, lastErr
+}
+
+// isVolumeSnapshotReady returns true if the VolumeSnapshot's status.readyToUse is set
+func isVolumeSnapshotReady(snapshot unstructured.Unstructured) bool {
+	ready, found, err := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	return err == nil && found && ready
+}
+
+// getSnapshotAPIVersion returns the preferred snapshot API version served for the
+// given snapshot CRD, which is v1 if available and v1beta1 otherwise
+func getSnapshotAPIVersion(client *clientset.Clientset, crdName string) (string, error) {
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting CRD %s: %v", crdName, err)
+	}
+	served := make(map[string]bool)
+	for _, version := range crd.Spec.Versions {
+		served[version.Name] = version.Served
+	}
+	for _, version := range []string{"v1", "v1beta1"} {
+		if served[version] {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("CRD %s does not serve a supported snapshot version", crdName)
+}
+
+// SnapshotDataSourceRef returns the dataSource to use in PVCs that restore from a
+// CSI VolumeSnapshot, along with its apiGroup. The name of the snapshot has to
+// be filled in by the caller. The apiGroup of a dataSource doesn't include the
+// version, so it is the same for v1 and v1beta1 snapshots, but an error is
+// returned if the cluster doesn't serve either of them since the provisioner
+// would ignore the dataSource.
+func SnapshotDataSourceRef(client *clientset.Clientset) (*v1.TypedLocalObjectReference, string, error) {
+	if _, err := getSnapshotAPIVersion(client, volumeSnapshotCRDName); err != nil {
+		return nil, "", err
+	}
+	apiGroup := snapshotGroup
+	return &v1.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     "VolumeSnapshot",
+	}, apiGroup, nil
+}
+
+// EnsureVolumeSnapshotClass creates a VolumeSnapshotClass with the given name for
+// the driver if it doesn't exist. If a class with the name already exists it must
+// be for the same driver.
+func EnsureVolumeSnapshotClass(client *clientset.Clientset, driver, className string, deletionPolicy string) error {
+	version, err := getSnapshotAPIVersion(client, volumeSnapshotClassCRDName)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	gvr := schema.GroupVersionResource{Group: snapshotGroup, Version: version, Resource: "volumesnapshotclasses"}
+
+	existing, err := dynamicClient.Resource(gvr).Get(context.TODO(), className, metav1.GetOptions{})
+	if err == nil {
+		existingDriver, _, _ := unstructured.NestedString(existing.Object, "driver")
+		if existingDriver != driver {
+			return fmt.Errorf("VolumeSnapshotClass %s already exists for driver %s, expected %s", className, existingDriver, driver)
+		}
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	class := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": snapshotGroup + "/" + version,
+			"kind":       "VolumeSnapshotClass",
+			"metadata": map[string]interface{}{
+				"name": className,
+			},
+			"driver":         driver,
+			"deletionPolicy": deletionPolicy,
+		},
+	}
+	_, err = dynamicClient.Resource(gvr).Create(context.TODO(), class, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// GetInProgressSnapshotsForPVCs returns the VolumeSnapshots that are not yet
+// ready for any of the given PVCs. The returned map is keyed by namespace/pvc.
+func GetInProgressSnapshotsForPVCs(pvcs []v1.PersistentVolumeClaim) (map[string]string, error) {
+	pvcsByNamespace := make(map[string]map[string]bool)
+	for _, pvc := range pvcs {
+		if _, ok := pvcsByNamespace[pvc.Namespace]; !ok {
+			pvcsByNamespace[pvc.Namespace] = make(map[string]bool)
+		}
+		pvcsByNamespace[pvc.Namespace][pvc.Name] = true
+	}
+
+	inProgress := make(map[string]string)
+	for namespace, names := range pvcsByNamespace {
+		snapshots, err := listVolumeSnapshots(namespace, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			pvcName := getVolumeSnapshotSourcePVC(snapshot)
+			if !names[pvcName] || snapshot.GetDeletionTimestamp() != nil || isVolumeSnapshotReady(snapshot) {
+				continue
+			}
+			inProgress[namespace+"/"+pvcName] = snapshot.GetName()
+		}
+	}
+	return inProgress, nil
+}
+
+// getVolumeSnapshotSourcePVC returns the name of the PVC the VolumeSnapshot was
+// taken from, or an empty string if it was pre-provisioned
+func getVolumeSnapshotSourcePVC(snapshot unstructured.Unstructured) string {
+	name, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+	return name
+}
+
+// ComputeRestoreCapacity returns the total capacity required to restore the given
+// VolumeSnapshots, computed from their status.restoreSize. For snapshots that
+// don't report a restoreSize the storage requested by the source PVC is used
+// instead.
+func ComputeRestoreCapacity(snapshotNames []string, namespace string) (resource.Quantity, error) {
+	total := resource.Quantity{}
+	for _, name := range snapshotNames {
+		snapshot, err := getVolumeSnapshot(namespace, name)
+		if err != nil {
+			return resource.Quantity{}, fmt.Errorf("error getting VolumeSnapshot [%s] %s: %v", namespace, name, err)
+		}
+
+		restoreSize, found, err := unstructured.NestedString(snapshot.Object, "status", "restoreSize")
+		if err == nil && found && restoreSize != "" {
+			size, err := resource.ParseQuantity(restoreSize)
+			if err != nil {
+				return resource.Quantity{}, fmt.Errorf("invalid restoreSize %s for VolumeSnapshot [%s] %s: %v", restoreSize, namespace, name, err)
+			}
+			total.Add(size)
+			continue
+		}
+
+		pvcName := getVolumeSnapshotSourcePVC(*snapshot)
+		if pvcName == "" {
+			return resource.Quantity{}, fmt.Errorf("VolumeSnapshot [%s] %s has no restoreSize or source PVC", namespace, name)
+		}
+		pvc, err := Instance().Core().GetPersistentVolumeClaim(pvcName, namespace)
+		if err != nil {
+			return resource.Quantity{}, fmt.Errorf("error getting source PVC for VolumeSnapshot [%s] %s: %v", namespace, name, err)
+		}
+		size, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			return resource.Quantity{}, fmt.Errorf("source PVC [%s] %s for VolumeSnapshot %s has no storage request", namespace, pvcName, name)
+		}
+		logrus.Warnf("VolumeSnapshot [%s] %s has no restoreSize, using %s requested by PVC %s", namespace, name, size.String(), pvcName)
+		total.Add(size)
+	}
+	return total, nil
+}
+
+// ListOrphanedSnapshotContents returns the VolumeSnapshotContents for the given
+// driver whose VolumeSnapshot no longer exists. Contents for all drivers are
+// checked if driverName is empty. Nothing is deleted.
+func ListOrphanedSnapshotContents(client *clientset.Clientset, driverName string) ([]unstructured.Unstructured, error) {
+	version, err := getSnapshotAPIVersion(client, volumeSnapshotContentCRDName)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := getDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	contentGVR := schema.GroupVersionResource{Group: snapshotGroup, Version: version, Resource: "volumesnapshotcontents"}
+	snapshotGVR := schema.GroupVersionResource{Group: snapshotGroup, Version: version, Resource: "volumesnapshots"}
+
+	contents, err := dynamicClient.Resource(contentGVR).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	orphaned := make([]unstructured.Unstructured, 0)
+	for _, content := range contents.Items {
+		if driverName != "" {
+			if driver, _, _ := unstructured.NestedString(content.Object, "spec", "driver"); driver != driverName {
+				continue
+			}
+		}
+		ref, found, err := unstructured.NestedStringMap(content.Object, "spec", "volumeSnapshotRef")
+		if err != nil || !found || ref["name"] == "" {
+			orphaned = append(orphaned, content)
+			continue
+		}
+
+		snapshot, err := dynamicClient.Resource(snapshotGVR).Namespace(ref["namespace"]).Get(context.TODO(), ref["name"], metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			orphaned = append(orphaned, content)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// A VolumeSnapshot with the same name could have been created after the
+		// original one was deleted
+		if ref["uid"] != "" && string(snapshot.GetUID()) != ref["uid"] {
+			orphaned = append(orphaned, content)
+		}
+	}
+	return orphaned, nil
+}
+
+// ValidateVolumeModeForSnapshot returns an error if the given driver can't
+// snapshot volumes with the volume mode of the PVC
+func ValidateVolumeModeForSnapshot(pvc *v1.PersistentVolumeClaim, driverName string) error {
+	mode := v1.PersistentVolumeFilesystem
+	if pvc.Spec.VolumeMode != nil {
+		mode = *pvc.Spec.VolumeMode
+	}
+	supported, ok := snapshotVolumeModes[driverName]
+	if !ok {
+		supported = []v1.PersistentVolumeMode{v1.PersistentVolumeFilesystem}
+	}
+	for _, supportedMode := range supported {
+		if mode == supportedMode {
+			return nil
+		}
+	}
+	return fmt.Errorf("driver %s does not support snapshots of PVC [%s] %s with volume mode %s",
+		driverName, pvc.Namespace, pvc.Name, mode)
+}
+
+// CheckSnapshotControllerRunning checks that the external snapshot controller is
+// deployed in the cluster and has at least one ready replica. Without it CSI
+// VolumeSnapshots are never bound and group snapshots stall.
+func CheckSnapshotControllerRunning(client kubernetes.Interface) error {
+	found := make([]string, 0)
+	for _, selector := range snapshotControllerSelectors {
+		deployments, err := client.AppsV1().Deployments(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return fmt.Errorf("error listing snapshot controller deployments: %v", err)
+		}
+		for _, deploy := range deployments.Items {
+			if deploy.Status.ReadyReplicas > 0 {
+				return nil
+			}
+			found = append(found, fmt.Sprintf("[%s] %s", deploy.Namespace, deploy.Name))
+		}
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("snapshot controller deployment has no ready replicas: %v", found)
+	}
+	return fmt.Errorf("snapshot controller deployment not found, looked for labels %v", snapshotControllerSelectors)
+}
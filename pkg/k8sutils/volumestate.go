@@ -0,0 +1,183 @@
+package k8sutils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// VolumeStateFunc returns the state of the volume with the given ID as reported
+// by the storage backend
+type VolumeStateFunc func(volumeID string) (string, error)
+
+// VolumeUsageFunc returns the number of bytes used by the volume with the given
+// ID as reported by the storage backend
+type VolumeUsageFunc func(volumeID string) (uint64, error)
+
+// VolumeNodesFunc returns the names of the nodes that hold the data for the
+// volume with the given ID as reported by the storage backend
+type VolumeNodesFunc func(volumeID string) ([]string, error)
+
+var (
+	volumeStateFuncs     = make(map[string]VolumeStateFunc)
+	volumeStateFuncsLock sync.RWMutex
+	volumeUsageFuncs     = make(map[string]VolumeUsageFunc)
+	volumeUsageFuncsLock sync.RWMutex
+	volumeNodesFuncs     = make(map[string]VolumeNodesFunc)
+	volumeNodesFuncsLock sync.RWMutex
+)
+
+// RegisterVolumeStateFunc registers the function used to get the state of the
+// volumes for the given driver. Drivers register this when they are initialized
+// since this package can't depend on the volume drivers.
+func RegisterVolumeStateFunc(driverName string, fn VolumeStateFunc) {
+	volumeStateFuncsLock.Lock()
+	defer volumeStateFuncsLock.Unlock()
+	volumeStateFuncs[driverName] = fn
+}
+
+// FilterPVCsByVolumeState returns the PVCs whose volumes are in one of the
+// acceptable states according to the given driver. An error is returned if the
+// driver hasn't registered a VolumeStateFunc or if it fails to get the state of
+// a volume.
+func FilterPVCsByVolumeState(pvcs []v1.PersistentVolumeClaim, driverName string, acceptableStates []string) ([]v1.PersistentVolumeClaim, error) {
+	volumeStateFuncsLock.RLock()
+	getState, ok := volumeStateFuncs[driverName]
+	volumeStateFuncsLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("driver %s does not support getting the state of volumes", driverName)
+	}
+
+	acceptable := make(map[string]bool)
+	for _, state := range acceptableStates {
+		acceptable[state] = true
+	}
+	filtered := make([]v1.PersistentVolumeClaim, 0)
+	for i := range pvcs {
+		volumeID, err := Instance().Core().GetVolumeForPersistentVolumeClaim(&pvcs[i])
+		if err != nil {
+			return nil, err
+		}
+		state, err := getState(volumeID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting state of volume %s for PVC [%s] %s from driver %s: %v",
+				volumeID, pvcs[i].Namespace, pvcs[i].Name, driverName, err)
+		}
+		if acceptable[state] {
+			filtered = append(filtered, pvcs[i])
+		}
+	}
+	return filtered, nil
+}
+
+// RegisterVolumeUsageFunc registers the function used to get the usage of the
+// volumes for the given driver
+func RegisterVolumeUsageFunc(driverName string, fn VolumeUsageFunc) {
+	volumeUsageFuncsLock.Lock()
+	defer volumeUsageFuncsLock.Unlock()
+	volumeUsageFuncs[driverName] = fn
+}
+
+// GetVolumeUsageForPVCs returns the space used by the volume of each of the given
+// PVCs according to the driver, keyed by PVC name. The storage requested by the
+// PVC is returned for any volume whose usage the driver can't report.
+func GetVolumeUsageForPVCs(pvcs []v1.PersistentVolumeClaim, driverName string) (map[string]resource.Quantity, error) {
+	volumeUsageFuncsLock.RLock()
+	getUsage, ok := volumeUsageFuncs[driverName]
+	volumeUsageFuncsLock.RUnlock()
+
+	usage := make(map[string]resource.Quantity)
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		if ok {
+			volumeID, err := Instance().Core().GetVolumeForPersistentVolumeClaim(pvc)
+			if err != nil {
+				return nil, err
+			}
+			used, err := getUsage(volumeID)
+			if err == nil {
+				usage[pvc.Name] = *resource.NewQuantity(int64(used), resource.BinarySI)
+				continue
+			}
+			logrus.Warnf("Error getting usage of volume %s for PVC [%s] %s from driver %s, using requested size: %v",
+				volumeID, pvc.Namespace, pvc.Name, driverName, err)
+		}
+		requested, found := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		if !found {
+			return nil, fmt.Errorf("PVC [%s] %s does not have a storage request", pvc.Namespace, pvc.Name)
+		}
+		usage[pvc.Name] = requested
+	}
+	return usage, nil
+}
+
+// RegisterVolumeNodesFunc registers the function used to get the nodes that
+// hold the data for the volumes of the given driver
+func RegisterVolumeNodesFunc(driverName string, fn VolumeNodesFunc) {
+	volumeNodesFuncsLock.Lock()
+	defer volumeNodesFuncsLock.Unlock()
+	volumeNodesFuncs[driverName] = fn
+}
+
+// GetPVCsOnNode returns the bound PVCs, across all namespaces, whose volumes
+// have data on the given node. Drivers that register a VolumeNodesFunc are asked
+// where the data for each volume is. For other drivers the node is found from
+// the nodeAffinity of the PV, or the VolumeAttachments for the driver, like
+// GetNodesForPVCGroup does.
+func GetPVCsOnNode(nodeName, driverName string) ([]v1.PersistentVolumeClaim, error) {
+	volumeNodesFuncsLock.RLock()
+	getNodes, ok := volumeNodesFuncs[driverName]
+	volumeNodesFuncsLock.RUnlock()
+
+	pvcList, err := Instance().Core().GetPersistentVolumeClaims("", nil)
+	if err != nil {
+		return nil, err
+	}
+	var attachments *storagev1.VolumeAttachmentList
+	pvcs := make([]v1.PersistentVolumeClaim, 0)
+	for _, pvc := range pvcList.Items {
+		if pvc.Spec.VolumeName == "" || pvc.Status.Phase != v1.ClaimBound {
+			continue
+		}
+		pv, err := Instance().Core().GetPersistentVolume(pvc.Spec.VolumeName)
+		if err != nil {
+			return nil, err
+		}
+
+		var pvNodes []string
+		if ok {
+			volumeID, err := Instance().Core().GetVolumeForPersistentVolumeClaim(&pvc)
+			if err != nil {
+				return nil, err
+			}
+			if pvNodes, err = getNodes(volumeID); err != nil {
+				return nil, fmt.Errorf("error getting nodes for volume %s of PVC [%s] %s from driver %s: %v",
+					volumeID, pvc.Namespace, pvc.Name, driverName, err)
+			}
+		} else {
+			pvNodes = getPVAffinityNodes(pv)
+			if len(pvNodes) == 0 {
+				if attachments == nil {
+					if attachments, err = Instance().Storage().ListVolumeAttachments(); err != nil {
+						return nil, err
+					}
+				}
+				pvNodes = getPVAttachedNodes(pv.Name, driverName, attachments)
+			}
+		}
+		for _, node := range pvNodes {
+			// Kubernetes lower cases node names, so drivers may report them with a
+			// different case
+			if strings.EqualFold(node, nodeName) {
+				pvcs = append(pvcs, pvc)
+				break
+			}
+		}
+	}
+	return pvcs, nil
+}
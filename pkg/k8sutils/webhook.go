@@ -0,0 +1,256 @@
+package k8sutils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// StorkWebhookConfigName - name of the stork MutatingWebhookConfiguration
+	StorkWebhookConfigName = "stork-webhooks-cfg"
+	// StorkWebhookSecretName - name of the secret holding the stork webhook serving cert
+	StorkWebhookSecretName = "stork-webhook-secret"
+	// storkWebhookCertKey is the key in the webhook secret that holds the
+	// self-signed cert, which is also used as the CA bundle
+	storkWebhookCertKey = "privCert"
+	// tlsCertKey is the standard key for certs in kubernetes.io/tls secrets
+	tlsCertKey = "tls.crt"
+)
+
+// getWebhookCABundles returns the caBundle of every webhook in the stork
+// MutatingWebhookConfiguration, keyed by webhook name. The v1 API is tried
+// first with a fallback to v1beta1 for older clusters.
+func getWebhookCABundles() (map[string][]byte, error) {
+	bundles := make(map[string][]byte)
+	cfg, err := Instance().AdmissionRegistration().GetMutatingWebhookConfiguration(StorkWebhookConfigName)
+	if err == nil {
+		for _, hook := range cfg.Webhooks {
+			bundles[hook.Name] = hook.ClientConfig.CABundle
+		}
+		return bundles, nil
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	cfgV1beta1, err := Instance().AdmissionRegistration().GetMutatingWebhookConfigurationV1beta1(StorkWebhookConfigName)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range cfgV1beta1.Webhooks {
+		bundles[hook.Name] = hook.ClientConfig.CABundle
+	}
+	return bundles, nil
+}
+
+// ValidateWebhookCABundle checks that the caBundle in the stork webhook
+// configuration matches the cert in the stork webhook secret in the given
+// namespace. An error is returned if they have diverged, for example after a
+// cert rotation that didn't update the webhook configuration.
+func ValidateWebhookCABundle(namespace string) error {
+	cert, err := getWebhookSecretCert(namespace)
+	if err != nil {
+		return err
+	}
+
+	bundles, err := getWebhookCABundles()
+	if err != nil {
+		return fmt.Errorf("error getting webhook configuration %s: %v", StorkWebhookConfigName, err)
+	}
+	if len(bundles) == 0 {
+		return fmt.Errorf("webhook configuration %s has no webhooks", StorkWebhookConfigName)
+	}
+	for name, bundle := range bundles {
+		if !bytes.Equal(bytes.TrimSpace(bundle), bytes.TrimSpace(cert)) {
+			return fmt.Errorf("caBundle for webhook %s in %s does not match the cert in secret [%s] %s",
+				name, StorkWebhookConfigName, namespace, StorkWebhookSecretName)
+		}
+	}
+	return nil
+}
+
+// getWebhookSecretCert returns the PEM encoded cert from the stork webhook secret
+func getWebhookSecretCert(namespace string) ([]byte, error) {
+	secret, err := Instance().Core().GetSecret(StorkWebhookSecretName, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook secret [%s] %s: %v", namespace, StorkWebhookSecretName, err)
+	}
+	for _, key := range []string{storkWebhookCertKey, tlsCertKey} {
+		if cert, ok := secret.Data[key]; ok && len(cert) > 0 {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook secret [%s] %s has no cert", namespace, StorkWebhookSecretName)
+}
+
+// GetStorkCertExpiry returns the time at which the stork webhook serving cert in
+// the given namespace expires
+func GetStorkCertExpiry(namespace string) (time.Time, error) {
+	data, err := getWebhookSecretCert(namespace)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("webhook secret [%s] %s does not contain a PEM encoded cert", namespace, StorkWebhookSecretName)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing cert from webhook secret [%s] %s: %v", namespace, StorkWebhookSecretName, err)
+	}
+	return cert.NotAfter, nil
+}
+
+// webhookPolicy holds the fields of a webhook that decide whether it can block
+// requests while stork is down
+type webhookPolicy struct {
+	name              string
+	failurePolicy     string
+	serviceNamespace  string
+	namespaceSelector *metav1.LabelSelector
+	objectSelector    *metav1.LabelSelector
+}
+
+// getWebhookPolicies returns the failure policy and selectors of every webhook in
+// the stork MutatingWebhookConfiguration. The v1 API is tried first with a
+// fallback to v1beta1 for older clusters, using the defaults of each version
+// for webhooks that don't set a failure policy.
+func getWebhookPolicies() ([]webhookPolicy, error) {
+	policies := make([]webhookPolicy, 0)
+	cfg, err := Instance().AdmissionRegistration().GetMutatingWebhookConfiguration(StorkWebhookConfigName)
+	if err == nil {
+		for _, hook := range cfg.Webhooks {
+			policy := webhookPolicy{
+				name:              hook.Name,
+				failurePolicy:     string(admissionv1.Fail),
+				namespaceSelector: hook.NamespaceSelector,
+				objectSelector:    hook.ObjectSelector,
+			}
+			if hook.FailurePolicy != nil {
+				policy.failurePolicy = string(*hook.FailurePolicy)
+			}
+			if hook.ClientConfig.Service != nil {
+				policy.serviceNamespace = hook.ClientConfig.Service.Namespace
+			}
+			policies = append(policies, policy)
+		}
+		return policies, nil
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	cfgV1beta1, err := Instance().AdmissionRegistration().GetMutatingWebhookConfigurationV1beta1(StorkWebhookConfigName)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range cfgV1beta1.Webhooks {
+		policy := webhookPolicy{
+			name:              hook.Name,
+			failurePolicy:     string(admissionv1beta1.Ignore),
+			namespaceSelector: hook.NamespaceSelector,
+			objectSelector:    hook.ObjectSelector,
+		}
+		if hook.FailurePolicy != nil {
+			policy.failurePolicy = string(*hook.FailurePolicy)
+		}
+		if hook.ClientConfig.Service != nil {
+			policy.serviceNamespace = hook.ClientConfig.Service.Namespace
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// ValidateWebhookFailurePolicy returns the failure policy of the stork webhooks
+// served from the given namespace and whether the configuration is risky. It is
+// risky if a webhook fails requests when stork can't be reached and doesn't use a
+// namespace or object selector to limit the requests it intercepts, since every
+// matching request in the cluster would then be blocked while stork is down.
+func ValidateWebhookFailurePolicy(namespace string) (string, bool, error) {
+	policies, err := getWebhookPolicies()
+	if err != nil {
+		return "", false, fmt.Errorf("error getting webhook configuration %s: %v", StorkWebhookConfigName, err)
+	}
+
+	policy := ""
+	for _, hook := range policies {
+		if hook.serviceNamespace != "" && hook.serviceNamespace != namespace {
+			continue
+		}
+		policy = hook.failurePolicy
+		if hook.failurePolicy == string(admissionv1.Fail) &&
+			isEmptyLabelSelector(hook.namespaceSelector) &&
+			isEmptyLabelSelector(hook.objectSelector) {
+			return policy, true, nil
+		}
+	}
+	if policy == "" {
+		return "", false, fmt.Errorf("webhook configuration %s has no webhooks for namespace %s", StorkWebhookConfigName, namespace)
+	}
+	return policy, false, nil
+}
+
+// isEmptyLabelSelector returns true if the selector matches everything
+func isEmptyLabelSelector(selector *metav1.LabelSelector) bool {
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
+
+// GetWebhookNamespaceScope returns the namespaces whose requests are intercepted
+// by the stork webhooks served from the given namespace, and the ones that are
+// excluded by the namespaceSelector of the webhooks. A namespace is included if
+// any of the webhooks selects it.
+func GetWebhookNamespaceScope(namespace string) ([]string, []string, error) {
+	policies, err := getWebhookPolicies()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting webhook configuration %s: %v", StorkWebhookConfigName, err)
+	}
+	selectors := make([]labels.Selector, 0)
+	for _, hook := range policies {
+		if hook.serviceNamespace != "" && hook.serviceNamespace != namespace {
+			continue
+		}
+		selector := labels.Everything()
+		if hook.namespaceSelector != nil {
+			if selector, err = metav1.LabelSelectorAsSelector(hook.namespaceSelector); err != nil {
+				return nil, nil, fmt.Errorf("invalid namespaceSelector for webhook %s: %v", hook.name, err)
+			}
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 0 {
+		return nil, nil, fmt.Errorf("webhook configuration %s has no webhooks for namespace %s", StorkWebhookConfigName, namespace)
+	}
+
+	namespaces, err := Instance().Core().ListNamespaces(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	included := make([]string, 0)
+	excluded := make([]string, 0)
+	for _, ns := range namespaces.Items {
+		matched := false
+		for _, selector := range selectors {
+			if selector.Matches(labels.Set(ns.Labels)) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			included = append(included, ns.Name)
+		} else {
+			excluded = append(excluded, ns.Name)
+		}
+	}
+	sort.Strings(included)
+	sort.Strings(excluded)
+	return included, excluded, nil
+}
@@ -1,7 +1,9 @@
 package objectstore
 
 import (
+	"context"
 	"fmt"
+	"io"
 
 	stork_api "github.com/libopenstorage/stork/pkg/apis/stork/v1alpha1"
 	"github.com/libopenstorage/stork/pkg/objectstore/azure"
@@ -63,3 +65,21 @@ func GetObjLockInfo(backupLocation *stork_api.BackupLocation) (*common.ObjLockIn
 		return nil, fmt.Errorf("invalid backupLocation type: %v", backupLocation.Location.Type)
 	}
 }
+
+// ValidateBackupLocationReachable checks that the bucket for the given backup
+// location can be listed with the configured credentials
+func ValidateBackupLocationReachable(backupLocation *stork_api.BackupLocation) error {
+	bucket, err := GetBucket(backupLocation)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = bucket.Close()
+	}()
+
+	if _, err := bucket.List(&blob.ListOptions{}).Next(context.TODO()); err != nil && err != io.EOF {
+		return fmt.Errorf("error listing bucket %v for backupLocation [%v] %v: %v",
+			backupLocation.Location.Path, backupLocation.Namespace, backupLocation.Name, err)
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/portworx/sched-ops/k8s/core"
 	"github.com/portworx/sched-ops/k8s/dynamic"
 	errors "github.com/portworx/sched-ops/k8s/errors"
+	storkops "github.com/portworx/sched-ops/k8s/stork"
 	"github.com/sirupsen/logrus"
 	"github.com/skyrings/skyring-common/tools/uuid"
 	v1 "k8s.io/api/core/v1"
@@ -233,6 +234,18 @@ func ExecuteRule(
 	rType Type,
 	owner runtime.Object,
 	podNamespace string,
+) (chan bool, error) {
+	return executeRule(rule, rType, owner, podNamespace, nil)
+}
+
+// executeRule is ExecuteRule restricted to the pods with the given UIDs. All the
+// pods matching the rule selectors are used if podUIDs is nil.
+func executeRule(
+	rule *stork_api.Rule,
+	rType Type,
+	owner runtime.Object,
+	podNamespace string,
+	podUIDs map[types.UID]bool,
 ) (chan bool, error) {
 	// Validate the rule. Don't depend on callers to invoke this
 	if err := ValidateRule(rule, rType); err != nil {
@@ -253,7 +266,11 @@ func ExecuteRule(
 			return nil, err
 		}
 
-		pods = append(pods, p.Items...)
+		for _, pod := range p.Items {
+			if podUIDs == nil || podUIDs[pod.UID] {
+				pods = append(pods, pod)
+			}
+		}
 	}
 
 	if len(pods) > 0 {
@@ -314,6 +331,50 @@ func ExecuteRule(
 	return nil, nil
 }
 
+// RunPreSnapshotRule runs the pre-exec rule with the given name from the given
+// namespace against the pods that use the PVCs, like the snapshot controllers do
+// for their snapshots. The owner is the object the rule is run for and is used
+// to track the pods with running commands. Rules with background actions aren't
+// supported since the caller would have no way to terminate them.
+func RunPreSnapshotRule(owner runtime.Object, namespace, ruleName string, pvcs []v1.PersistentVolumeClaim) error {
+	return runSnapshotRule(owner, namespace, ruleName, pvcs, PreExecRule)
+}
+
+func runSnapshotRule(owner runtime.Object, namespace, ruleName string, pvcs []v1.PersistentVolumeClaim, ruleType Type) error {
+	r, err := storkops.Instance().GetRule(ruleName, namespace)
+	if err != nil {
+		return err
+	}
+	for _, item := range r.Rules {
+		for _, action := range item.Actions {
+			if action.Background {
+				return fmt.Errorf("rule [%s] %s has background actions which are not supported when run outside a controller",
+					namespace, ruleName)
+			}
+		}
+	}
+
+	podUIDs := make(map[string]map[types.UID]bool)
+	for _, pvc := range pvcs {
+		pods, err := core.Instance().GetPodsUsingPVC(pvc.Name, pvc.Namespace)
+		if err != nil {
+			return fmt.Errorf("failed to get pods using PVC [%s] %s: %v", pvc.Namespace, pvc.Name, err)
+		}
+		for _, pod := range pods {
+			if podUIDs[pod.Namespace] == nil {
+				podUIDs[pod.Namespace] = make(map[types.UID]bool)
+			}
+			podUIDs[pod.Namespace][pod.UID] = true
+		}
+	}
+	for podNamespace, uids := range podUIDs {
+		if _, err := executeRule(r, ruleType, owner, podNamespace, uids); err != nil {
+			return fmt.Errorf("failed to run rule [%s] %s in namespace %s: %v", namespace, ruleName, podNamespace, err)
+		}
+	}
+	return nil
+}
+
 // executeCommandAction executes the command type action on given pods:
 func executeCommandAction(
 	pods []v1.Pod,
@@ -339,9 +400,13 @@ func executeCommandAction(
 	// and then if both of them are missing, default to stork image repo
 	if len(os.Getenv(cmdExecutorImageRegistryEnvVar)) == 0 {
 		// If env is not set get the values from stork deployment spec.
+		storkNamespace, err := k8sutils.GetStorkPodNamespaceCached()
+		if err != nil {
+			return fmt.Errorf("failed to get stork namespace due to: %v", err)
+		}
 		registry, registrySecret, err := k8sutils.GetImageRegistryFromDeployment(
 			k8sutils.StorkDeploymentName,
-			k8sutils.DefaultAdminNamespace,
+			storkNamespace,
 		)
 		if err != nil {
 			return err
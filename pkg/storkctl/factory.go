@@ -3,6 +3,7 @@ package storkctl
 import (
 	"fmt"
 
+	"github.com/libopenstorage/stork/pkg/k8sutils"
 	appsops "github.com/portworx/sched-ops/k8s/apps"
 	"github.com/portworx/sched-ops/k8s/batch"
 	"github.com/portworx/sched-ops/k8s/core"
@@ -132,6 +133,7 @@ func (f *factory) UpdateConfig() error {
 	appsops.Instance().SetConfig(config)
 	dynamicops.Instance().SetConfig(config)
 	externalstorageops.Instance().SetConfig(config)
+	k8sutils.SetConfig(config)
 	return nil
 }
 
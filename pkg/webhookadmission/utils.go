@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/libopenstorage/stork/pkg/k8sutils"
 	"github.com/libopenstorage/stork/pkg/version"
 	"github.com/portworx/sched-ops/k8s/admissionregistration"
 	"github.com/portworx/sched-ops/k8s/core"
@@ -73,6 +74,9 @@ func CreateMutateWebhook(caBundle []byte, ns string) error {
 	req := &admissionv1beta1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: storkAdmissionController,
+			Labels: map[string]string{
+				k8sutils.StorkVersionLabel: version.Version,
+			},
 		},
 		Webhooks: []admissionv1beta1.MutatingWebhook{webhook},
 	}
@@ -201,6 +205,9 @@ func createWebhookV1(caBundle []byte, ns string) error {
 	req := &admissionv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: storkAdmissionController,
+			Labels: map[string]string{
+				k8sutils.StorkVersionLabel: version.Version,
+			},
 		},
 		Webhooks: []admissionv1.MutatingWebhook{webhook},
 	}